@@ -1,17 +1,98 @@
 // Package config provides configuration information
 package config
 
-import "github.com/caarlos0/env"
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env"
+)
 
 type Config struct {
-	SigningKey string `env:"SIGNING_KEY" envDefault:"ew4t137tr1eyfg1ryg4ryerg2743gr2"`
+	SigningKey               string        `env:"SIGNING_KEY" envDefault:"ew4t137tr1eyfg1ryg4ryerg2743gr2"`
+	PriceMonitorInterval     time.Duration `env:"PRICE_MONITOR_INTERVAL" envDefault:"1s"`
+	PriceStreamRetryDelay    time.Duration `env:"PRICE_STREAM_RETRY_DELAY" envDefault:"1s"`
+	PriceStreamMaxRetryDelay time.Duration `env:"PRICE_STREAM_MAX_RETRY_DELAY" envDefault:"30s"`
+	PriceReplayBufferSize    int           `env:"PRICE_REPLAY_BUFFER_SIZE" envDefault:"1000"`
+	QuoteMaxAge              time.Duration `env:"QUOTE_MAX_AGE" envDefault:"5s"`
+	PriceWSAllowedOrigins    []string      `env:"PRICE_WS_ALLOWED_ORIGINS"`
+	CandleShares             []string      `env:"CANDLE_SHARES"`
+	CandleHistorySize        int           `env:"CANDLE_HISTORY_SIZE" envDefault:"1000"`
+	MaxLeverage              float64       `env:"MAX_LEVERAGE" envDefault:"10"`
+	MaintenanceMarginRate    float64       `env:"MAINTENANCE_MARGIN_RATE" envDefault:"0.05"`
+	TradingStorePath         string        `env:"TRADING_STORE_PATH" envDefault:"trading.db"`
+	SettlementRetryInterval  time.Duration `env:"SETTLEMENT_RETRY_INTERVAL" envDefault:"5s"`
+	FeeFlat                  float64       `env:"FEE_FLAT" envDefault:"0"`
+	FeePercent               float64       `env:"FEE_PERCENT" envDefault:"0"`
+	FeeTiers                 []FeeTier     `env:"FEE_PER_SHARE_TIERS"`
+	PaperInitialBalance      float64       `env:"PAPER_INITIAL_BALANCE" envDefault:"100000"`
+	RiskMaxNotionalPerShare  float64       `env:"RISK_MAX_NOTIONAL_PER_SHARE" envDefault:"0"`
+	RiskMaxExposure          float64       `env:"RISK_MAX_EXPOSURE" envDefault:"0"`
+	RiskMaxOpenPositions     int           `env:"RISK_MAX_OPEN_POSITIONS" envDefault:"0"`
+	RiskMaxOrderBalanceRatio float64       `env:"RISK_MAX_ORDER_BALANCE_RATIO" envDefault:"0"`
+	MarketCalendarPath       string        `env:"MARKET_CALENDAR_PATH"`
+	MarketClosedOrders       string        `env:"MARKET_CLOSED_ORDERS" envDefault:"reject"`
+}
+
+// FeeTier represents a per-share fee charged on trades of at least MinAmount shares
+type FeeTier struct {
+	MinAmount float64
+	PerShare  float64
 }
 
 // NewConfig creates a new Config instance
 func NewConfig() (*Config, error) {
 	cfg := &Config{}
-	if err := env.Parse(cfg); err != nil {
+	parsers := env.CustomParsers{
+		reflect.TypeOf([]FeeTier{}): parseFeeTiers,
+	}
+	if err := env.ParseWithFuncs(cfg, parsers); err != nil {
 		return nil, err
 	}
+	if cfg.MarketClosedOrders != MarketClosedReject && cfg.MarketClosedOrders != MarketClosedQueue {
+		return nil, fmt.Errorf("MARKET_CLOSED_ORDERS must be %q or %q, got %q", MarketClosedReject, MarketClosedQueue, cfg.MarketClosedOrders)
+	}
+	if cfg.PriceStreamRetryDelay <= 0 {
+		return nil, fmt.Errorf("PRICE_STREAM_RETRY_DELAY must be positive, got %v", cfg.PriceStreamRetryDelay)
+	}
+	if cfg.PriceStreamMaxRetryDelay < cfg.PriceStreamRetryDelay {
+		return nil, fmt.Errorf("PRICE_STREAM_MAX_RETRY_DELAY must not be below PRICE_STREAM_RETRY_DELAY %v, got %v",
+			cfg.PriceStreamRetryDelay, cfg.PriceStreamMaxRetryDelay)
+	}
+	// a position opened with the initial margin rate at or below the maintenance margin rate is liquidated on its first price
+	if cfg.MaxLeverage*cfg.MaintenanceMarginRate >= 1 {
+		return nil, fmt.Errorf("MAX_LEVERAGE %v times MAINTENANCE_MARGIN_RATE %v must be below 1", cfg.MaxLeverage, cfg.MaintenanceMarginRate)
+	}
+	if cfg.SettlementRetryInterval <= 0 {
+		return nil, fmt.Errorf("SETTLEMENT_RETRY_INTERVAL must be positive, got %v", cfg.SettlementRetryInterval)
+	}
 	return cfg, nil
 }
+
+// parseFeeTiers parses per-share fee tiers in the "minAmount:perShare,minAmount:perShare" format sorted by MinAmount
+func parseFeeTiers(value string) (interface{}, error) {
+	tiers := make([]FeeTier, 0)
+	for _, tier := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(tier), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid fee tier %q", tier)
+		}
+		minAmount, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("ParseFloat: %w", err)
+		}
+		perShare, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("ParseFloat: %w", err)
+		}
+		tiers = append(tiers, FeeTier{MinAmount: minAmount, PerShare: perShare})
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		return tiers[i].MinAmount < tiers[j].MinAmount
+	})
+	return tiers, nil
+}
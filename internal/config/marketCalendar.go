@@ -0,0 +1,46 @@
+// Package config provides configuration information
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// handling of market orders placed outside trading hours
+const (
+	MarketClosedReject = "reject"
+	MarketClosedQueue  = "queue"
+)
+
+// MarketCalendar struct represents trading sessions of exchanges and the exchanges shares are traded on,
+// shares without an exchange are traded on the default one
+type MarketCalendar struct {
+	DefaultExchange string                      `json:"default_exchange"`
+	Exchanges       map[string]ExchangeCalendar `json:"exchanges"`
+	Shares          map[string]string           `json:"shares"`
+}
+
+// ExchangeCalendar struct represents the regular session of an exchange in its time zone, its holidays and half-days closing early,
+// times are in the "15:04" format, dates in the "2006-01-02" format and weekdays are Monday to Friday if not set
+type ExchangeCalendar struct {
+	TimeZone string            `json:"time_zone"`
+	Open     string            `json:"open"`
+	Close    string            `json:"close"`
+	Weekdays []string          `json:"weekdays"`
+	Holidays []string          `json:"holidays"`
+	HalfDays map[string]string `json:"half_days"`
+}
+
+// LoadMarketCalendar reads the market calendar from the JSON file at the given path
+func LoadMarketCalendar(path string) (*MarketCalendar, error) {
+	data, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("ReadFile: %w", err)
+	}
+	calendar := &MarketCalendar{}
+	if err = json.Unmarshal(data, calendar); err != nil {
+		return nil, fmt.Errorf("Unmarshal: %w", err)
+	}
+	return calendar, nil
+}
@@ -0,0 +1,41 @@
+// Package handlers for handling echo requests
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// MarketAPIHandler struct represents a handler for Market API requests
+type MarketAPIHandler struct {
+	srv MarketAPIService
+}
+
+// NewMarketAPIHandler creates a new MarketAPIHandler
+func NewMarketAPIHandler(srv MarketAPIService) *MarketAPIHandler {
+	return &MarketAPIHandler{srv: srv}
+}
+
+// MarketAPIService represents a service for Market API requests
+type MarketAPIService interface {
+	GetMarketStatus(context.Context, string) (*model.MarketStatus, error)
+}
+
+// GetMarketStatus function returns whether the share from the share query is currently tradable
+func (h *MarketAPIHandler) GetMarketStatus(c echo.Context) error {
+	shareName := c.QueryParam("share")
+	if shareName == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "share is required")
+	}
+	status, err := h.srv.GetMarketStatus(c.Request().Context(), shareName)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"shareName": shareName}).Errorf("GetMarketStatus: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetMarketStatus: %v", err))
+	}
+	return c.JSON(http.StatusOK, status)
+}
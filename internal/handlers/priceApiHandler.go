@@ -0,0 +1,335 @@
+// Package handlers for handling echo requests
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// maxStreamedShares limits the number of shares a client of the price stream can subscribe to
+const maxStreamedShares = 100
+
+// sseHeartbeatInterval is the interval of comments keeping an idle event stream open through proxies
+const sseHeartbeatInterval = 15 * time.Second
+
+// PriceAPIHandler struct represents a handler for Price API requests, browsers may open the price WebSocket
+// from the allowed origins only, "*" allows any origin
+type PriceAPIHandler struct {
+	srv            PriceAPIService
+	quoteSrv       QuoteAPIService
+	candleSrv      CandleAPIService
+	allowedOrigins []string
+}
+
+// NewPriceAPIHandler creates a new PriceAPIHandler
+func NewPriceAPIHandler(srv PriceAPIService, quoteSrv QuoteAPIService, candleSrv CandleAPIService, allowedOrigins []string) *PriceAPIHandler {
+	return &PriceAPIHandler{srv: srv, quoteSrv: quoteSrv, candleSrv: candleSrv, allowedOrigins: allowedOrigins}
+}
+
+// PriceAPIService represents a service for Price API requests
+type PriceAPIService interface {
+	StreamPrices(context.Context, <-chan []string, func(*model.PriceTick) error) error
+	StreamPricesSince(context.Context, []string, uint64, func(*model.PriceTick) error) error
+}
+
+// QuoteAPIService represents a service for latest quotes of shares
+type QuoteAPIService interface {
+	GetQuote(context.Context, string) (*model.Quote, error)
+	GetQuotes(context.Context, []string) ([]*model.Quote, error)
+}
+
+// CandleAPIService represents a service for candles aggregated from the price stream
+type CandleAPIService interface {
+	GetCandles(context.Context, string, string, time.Time, time.Time) ([]*model.Candle, error)
+}
+
+// GetQuote function returns the latest quote of the share from the path, the quote is flagged stale if no fresh price is known
+func (h *PriceAPIHandler) GetQuote(c echo.Context) error {
+	shareName := c.Param("share")
+	quote, err := h.quoteSrv.GetQuote(c.Request().Context(), shareName)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"shareName": shareName}).Errorf("GetQuote: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetQuote: %v", err))
+	}
+	return c.JSON(http.StatusOK, quote)
+}
+
+// GetQuotes function returns the latest quotes of the comma-separated shares from the shares query
+func (h *PriceAPIHandler) GetQuotes(c echo.Context) error {
+	shares := parseShareList(c.QueryParam("shares"))
+	if len(shares) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "shares is required")
+	}
+	quotes, err := h.quoteSrv.GetQuotes(c.Request().Context(), shares)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"shares": shares}).Errorf("GetQuotes: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetQuotes: %v", err))
+	}
+	return c.JSON(http.StatusOK, quotes)
+}
+
+// PricesWS function upgrades the request to a WebSocket pushing prices of the shares the client subscribes to,
+// the client sends {"action":"subscribe","shares":[...]} and {"action":"unsubscribe","shares":[...]} at any time
+func (h *PriceAPIHandler) PricesWS(c echo.Context) error {
+	server := websocket.Server{Handshake: h.checkOrigin, Handler: func(ws *websocket.Conn) {
+		defer func() {
+			if err := ws.Close(); err != nil {
+				logrus.Errorf("Close: %v", err)
+			}
+		}()
+		ctx, cancel := context.WithCancel(c.Request().Context())
+		defer cancel()
+		var mu sync.Mutex
+		write := func(msg *model.PriceMessage) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return websocket.JSON.Send(ws, msg)
+		}
+		updates := make(chan []string)
+		go func() {
+			defer cancel()
+			readPriceCommands(ctx, ws, updates, write)
+		}()
+		err := h.srv.StreamPrices(ctx, updates, func(tick *model.PriceTick) error {
+			return write(priceMessage(tick))
+		})
+		if err != nil {
+			logrus.Errorf("StreamPrices: %v", err)
+		}
+	}}
+	server.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// checkOrigin accepts clients sending no Origin header, such as bots and scripts, and browsers from the allowed origins
+func (h *PriceAPIHandler) checkOrigin(config *websocket.Config, req *http.Request) error {
+	if req.Header.Get("Origin") == "" {
+		return nil
+	}
+	origin, err := websocket.Origin(config, req)
+	if err != nil {
+		return fmt.Errorf("Origin: %w", err)
+	}
+	for _, allowed := range h.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin.Scheme+"://"+origin.Host) {
+			config.Origin = origin
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %s is not allowed", origin)
+}
+
+// GetCandles function returns candles of the share from the path, the query contains the interval (1m, 5m, 15m, 1h or 1d)
+// and optionally from and to in RFC3339 format, a share unknown to the price service is not found
+func (h *PriceAPIHandler) GetCandles(c echo.Context) error {
+	shareName := c.Param("share")
+	interval := c.QueryParam("interval")
+	if interval == "" {
+		interval = model.CandleInterval1m
+	}
+	if _, ok := model.CandleIntervals[interval]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown interval %q", interval))
+	}
+	var from, to time.Time
+	var err error
+	if value := c.QueryParam("from"); value != "" {
+		from, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse(from): %v", err))
+		}
+	}
+	if value := c.QueryParam("to"); value != "" {
+		to, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse(to): %v", err))
+		}
+	}
+	candles, err := h.candleSrv.GetCandles(c.Request().Context(), shareName, interval, from, to)
+	var unknownShare *model.UnknownShareError
+	if errors.As(err, &unknownShare) {
+		return echo.NewHTTPError(http.StatusNotFound, unknownShare)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"shareName": shareName, "interval": interval}).Errorf("GetCandles: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetCandles: %v", err))
+	}
+	return c.JSON(http.StatusOK, candles)
+}
+
+// PricesSSE function streams prices of the shares from the shares query as Server-Sent Events for clients that cannot use WebSockets,
+// a client reconnecting with the Last-Event-ID header first receives the missed prices that are still kept
+func (h *PriceAPIHandler) PricesSSE(c echo.Context) error {
+	shares := parseShareList(c.QueryParam("shares"))
+	if len(shares) == 0 || len(shares) > maxStreamedShares {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("shares must list 1 to %d share names", maxStreamedShares))
+	}
+	var lastID uint64
+	if header := c.Request().Header.Get("Last-Event-ID"); header != "" {
+		var err error
+		lastID, err = strconv.ParseUint(header, 10, 64)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("ParseUint: %v", err))
+		}
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	res.Flush()
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	var mu sync.Mutex
+	write := func(event string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if _, err := res.Write([]byte(event)); err != nil {
+			return err
+		}
+		res.Flush()
+		return nil
+	}
+	// the response is reused by echo once the handler returns, so the heartbeat has to stop before that
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		ticker := time.NewTicker(sseHeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := write(": heartbeat\n\n"); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+	err := h.srv.StreamPricesSince(ctx, shares, lastID, func(tick *model.PriceTick) error {
+		data, err := json.Marshal(priceMessage(tick))
+		if err != nil {
+			return fmt.Errorf("Marshal: %w", err)
+		}
+		if tick.Feed != nil {
+			return write(fmt.Sprintf("event: %s\ndata: %s\n\n", model.PriceMessageFeed, data))
+		}
+		return write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", tick.ID, model.PriceMessagePrice, data))
+	})
+	cancel()
+	<-heartbeatDone
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"shares": shares}).Errorf("StreamPricesSince: %v", err)
+	}
+	return nil
+}
+
+// readPriceCommands applies subscribe and unsubscribe commands of the client to its set of shares, passes the new set to updates
+// and confirms it to the client until the connection is closed
+func readPriceCommands(ctx context.Context, ws *websocket.Conn, updates chan<- []string, write func(*model.PriceMessage) error) {
+	subscribed := make(map[string]struct{})
+	for {
+		cmd := &model.PriceCommand{}
+		if err := websocket.JSON.Receive(ws, cmd); err != nil {
+			return
+		}
+		err := applyPriceCommand(subscribed, cmd)
+		if err != nil {
+			if err = write(&model.PriceMessage{Type: model.PriceMessageError, Message: err.Error(), Timestamp: time.Now()}); err != nil {
+				return
+			}
+			continue
+		}
+		shares := make([]string, 0, len(subscribed))
+		for share := range subscribed {
+			shares = append(shares, share)
+		}
+		sort.Strings(shares)
+		select {
+		case <-ctx.Done():
+			return
+		case updates <- shares:
+		}
+		if err = write(&model.PriceMessage{Type: model.PriceMessageSubscribed, Shares: shares, Timestamp: time.Now()}); err != nil {
+			return
+		}
+	}
+}
+
+// applyPriceCommand adds shares of a subscribe command to the set or removes shares of an unsubscribe one
+func applyPriceCommand(subscribed map[string]struct{}, cmd *model.PriceCommand) error {
+	switch cmd.Action {
+	case model.PriceActionSubscribe:
+		for _, share := range cmd.Shares {
+			if share == "" {
+				return fmt.Errorf("share name is empty")
+			}
+		}
+		added := 0
+		for _, share := range cmd.Shares {
+			if _, ok := subscribed[share]; !ok {
+				added++
+			}
+		}
+		if len(subscribed)+added > maxStreamedShares {
+			return fmt.Errorf("at most %d shares can be subscribed to", maxStreamedShares)
+		}
+		for _, share := range cmd.Shares {
+			subscribed[share] = struct{}{}
+		}
+	case model.PriceActionUnsubscribe:
+		for _, share := range cmd.Shares {
+			delete(subscribed, share)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", cmd.Action)
+	}
+	return nil
+}
+
+// priceMessage converts the price tick or feed event into a message sent to clients of the price stream
+func priceMessage(tick *model.PriceTick) *model.PriceMessage {
+	if tick.Feed != nil {
+		return &model.PriceMessage{
+			Type:      model.PriceMessageFeed,
+			Shares:    tick.Feed.Shares,
+			Status:    tick.Feed.Status,
+			Message:   tick.Feed.Error,
+			Timestamp: tick.Timestamp,
+		}
+	}
+	return &model.PriceMessage{
+		ID:        tick.ID,
+		Type:      model.PriceMessagePrice,
+		ShareName: tick.ShareName,
+		Price:     tick.SharePrice,
+		Timestamp: tick.Timestamp,
+	}
+}
+
+// parseShareList splits the comma-separated list of share names skipping empty ones
+func parseShareList(value string) []string {
+	shares := make([]string, 0)
+	for _, share := range strings.Split(value, ",") {
+		if share = strings.TrimSpace(share); share != "" {
+			shares = append(shares, share)
+		}
+	}
+	return shares
+}
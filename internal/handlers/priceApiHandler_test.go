@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+)
+
+type fakePriceAPIService struct{}
+
+func (fakePriceAPIService) StreamPrices(ctx context.Context, _ <-chan []string, send func(*model.PriceTick) error) error {
+	err := send(&model.PriceTick{ID: 1, Shares: model.Shares{ShareName: "Apple", SharePrice: 100}})
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (fakePriceAPIService) StreamPricesSince(context.Context, []string, uint64, func(*model.PriceTick) error) error {
+	return nil
+}
+
+func newPriceWSServer(allowedOrigins []string) *httptest.Server {
+	e := echo.New()
+	h := NewPriceAPIHandler(fakePriceAPIService{}, nil, nil, allowedOrigins)
+	e.GET("/ws", h.PricesWS)
+	return httptest.NewServer(e)
+}
+
+func TestPricesWSAcceptsClientsWithoutOrigin(t *testing.T) {
+	server := newPriceWSServer([]string{"https://app.example.com"})
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: "+server.Listener.Addr().String()+"\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// the first frame is an unmasked text frame shorter than 126 bytes
+	header := make([]byte, 2)
+	_, err = io.ReadFull(reader, header)
+	require.NoError(t, err)
+	payload := make([]byte, header[1]&0x7f)
+	_, err = io.ReadFull(reader, payload)
+	require.NoError(t, err)
+	msg := &model.PriceMessage{}
+	require.NoError(t, json.Unmarshal(payload, msg))
+	require.Equal(t, "Apple", msg.ShareName)
+	require.Equal(t, 100.0, msg.Price)
+}
+
+func TestPricesWSChecksBrowserOrigin(t *testing.T) {
+	server := newPriceWSServer([]string{"https://app.example.com"})
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	ws, err := websocket.Dial(url, "", "https://app.example.com")
+	require.NoError(t, err)
+	msg := &model.PriceMessage{}
+	require.NoError(t, websocket.JSON.Receive(ws, msg))
+	require.Equal(t, "Apple", msg.ShareName)
+	require.NoError(t, ws.Close())
+
+	_, err = websocket.Dial(url, "", "https://evil.example.com")
+	require.Error(t, err)
+}
+
+type fakeCandleAPIService struct{}
+
+func (fakeCandleAPIService) GetCandles(_ context.Context, shareName, _ string, _, _ time.Time) ([]*model.Candle, error) {
+	if shareName != "Apple" {
+		return nil, &model.UnknownShareError{ShareName: shareName}
+	}
+	return []*model.Candle{}, nil
+}
+
+func TestGetCandlesOfUnknownShareIsNotFound(t *testing.T) {
+	e := echo.New()
+	h := NewPriceAPIHandler(fakePriceAPIService{}, nil, fakeCandleAPIService{}, nil)
+	e.GET("/prices/:share/candles", h.GetCandles)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/prices/Apple/candles?interval=1m", http.NoBody))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/prices/NoSuchShare/candles", http.NoBody))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,335 @@
+// Package handlers for handling echo requests
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	middlewr "github.com/eugenshima/trading-api/internal/middleware"
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// TradingAPIHandler struct represents a handler for Trading API requests
+type TradingAPIHandler struct {
+	srv TradingAPIService
+}
+
+// NewTradingAPIHandler creates a new TradingAPIHandler
+func NewTradingAPIHandler(srv TradingAPIService) *TradingAPIHandler {
+	return &TradingAPIHandler{srv: srv}
+}
+
+// TradingAPIService represents a service for Trading API requests
+type TradingAPIService interface {
+	OpenPosition(context.Context, uuid.UUID, *model.OpenPosition) (*model.Position, error)
+	ClosePosition(context.Context, uuid.UUID, *model.ClosePosition) (*model.Position, error)
+	IncreasePosition(context.Context, uuid.UUID, *model.IncreasePosition) (*model.Position, error)
+	GetPositions(context.Context, uuid.UUID, string) ([]*model.PositionPnL, error)
+	GetPortfolio(context.Context, uuid.UUID, string) (*model.Portfolio, error)
+	GetTradeHistory(context.Context, *model.TradeHistoryFilter, string) (*model.TradeHistory, error)
+	GetLiquidations(context.Context, uuid.UUID, string) ([]*model.Liquidation, error)
+	GetSettlements(context.Context, uuid.UUID, string) ([]*model.Settlement, error)
+	CreateOrder(context.Context, uuid.UUID, *model.CreateOrder) (*model.Order, error)
+	CreateBracketOrder(context.Context, uuid.UUID, *model.CreateBracketOrder) (*model.OrderGroup, error)
+	CreateOCOOrder(context.Context, uuid.UUID, *model.CreateOCOOrder) (*model.OrderGroup, error)
+	GetPendingOrders(context.Context, uuid.UUID, string) ([]*model.Order, error)
+	CancelOrder(context.Context, uuid.UUID, uuid.UUID) (*model.Order, error)
+	ResetPaperBalance(context.Context, uuid.UUID) (*model.Balance, error)
+}
+
+// OpenPosition function opens a position on a share for the profile from token payload
+func (h *TradingAPIHandler) OpenPosition(c echo.Context) error {
+	reqPosition := &model.OpenPosition{}
+	err := c.Bind(reqPosition)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqPosition": reqPosition}).Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Bind: %v", err))
+	}
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	position, err := h.srv.OpenPosition(c.Request().Context(), id, reqPosition)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id, "reqPosition": reqPosition}).Errorf("OpenPosition: %v", err)
+		return tradingError("OpenPosition", err)
+	}
+	return c.JSON(http.StatusOK, position)
+}
+
+// ClosePosition function closes a position or a part of it for the profile from token payload
+func (h *TradingAPIHandler) ClosePosition(c echo.Context) error {
+	reqPosition := &model.ClosePosition{}
+	err := c.Bind(reqPosition)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqPosition": reqPosition}).Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Bind: %v", err))
+	}
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	position, err := h.srv.ClosePosition(c.Request().Context(), id, reqPosition)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id, "reqPosition": reqPosition}).Errorf("ClosePosition: %v", err)
+		return tradingError("ClosePosition", err)
+	}
+	return c.JSON(http.StatusOK, position)
+}
+
+// IncreasePosition function adds to an open position of the profile from token payload
+func (h *TradingAPIHandler) IncreasePosition(c echo.Context) error {
+	reqPosition := &model.IncreasePosition{}
+	err := c.Bind(reqPosition)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqPosition": reqPosition}).Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Bind: %v", err))
+	}
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	position, err := h.srv.IncreasePosition(c.Request().Context(), id, reqPosition)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id, "reqPosition": reqPosition}).Errorf("IncreasePosition: %v", err)
+		return tradingError("IncreasePosition", err)
+	}
+	return c.JSON(http.StatusOK, position)
+}
+
+// GetPositions function returns open positions with unrealized P&L of the profile from token payload
+func (h *TradingAPIHandler) GetPositions(c echo.Context) error {
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	positions, err := h.srv.GetPositions(c.Request().Context(), id, c.QueryParam("account"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id}).Errorf("GetPositions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPositions: %v", err))
+	}
+	return c.JSON(http.StatusOK, positions)
+}
+
+// GetPortfolio function returns the balance and open positions summary of the profile from token payload
+func (h *TradingAPIHandler) GetPortfolio(c echo.Context) error {
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	portfolio, err := h.srv.GetPortfolio(c.Request().Context(), id, c.QueryParam("account"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id}).Errorf("GetPortfolio: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPortfolio: %v", err))
+	}
+	return c.JSON(http.StatusOK, portfolio)
+}
+
+// GetTradeHistory function returns closed positions of the profile from token payload,
+// the query may contain account, from and to in RFC3339 format, share, limit and cursor from the previous page
+func (h *TradingAPIHandler) GetTradeHistory(c echo.Context) error {
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	filter := &model.TradeHistoryFilter{
+		ProfileID: id,
+		Account:   c.QueryParam("account"),
+		ShareName: c.QueryParam("share"),
+	}
+	if from := c.QueryParam("from"); from != "" {
+		filter.From, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse(from): %v", err))
+		}
+	}
+	if to := c.QueryParam("to"); to != "" {
+		filter.To, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse(to): %v", err))
+		}
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Atoi(limit): %v", err))
+		}
+	}
+	history, err := h.srv.GetTradeHistory(c.Request().Context(), filter, c.QueryParam("cursor"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"filter": filter}).Errorf("GetTradeHistory: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetTradeHistory: %v", err))
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
+// GetLiquidations function returns liquidations of positions of the profile from token payload
+func (h *TradingAPIHandler) GetLiquidations(c echo.Context) error {
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	liquidations, err := h.srv.GetLiquidations(c.Request().Context(), id, c.QueryParam("account"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id}).Errorf("GetLiquidations: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetLiquidations: %v", err))
+	}
+	return c.JSON(http.StatusOK, liquidations)
+}
+
+// GetSettlements function returns the settlement ledger of position closes of the profile from token payload
+func (h *TradingAPIHandler) GetSettlements(c echo.Context) error {
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	settlements, err := h.srv.GetSettlements(c.Request().Context(), id, c.QueryParam("account"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id}).Errorf("GetSettlements: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetSettlements: %v", err))
+	}
+	return c.JSON(http.StatusOK, settlements)
+}
+
+// CreateOrder function places a market, limit or stop order for the profile from token payload
+func (h *TradingAPIHandler) CreateOrder(c echo.Context) error {
+	reqOrder := &model.CreateOrder{}
+	err := c.Bind(reqOrder)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqOrder": reqOrder}).Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Bind: %v", err))
+	}
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	order, err := h.srv.CreateOrder(c.Request().Context(), id, reqOrder)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id, "reqOrder": reqOrder}).Errorf("CreateOrder: %v", err)
+		return tradingError("CreateOrder", err)
+	}
+	return c.JSON(http.StatusOK, order)
+}
+
+// CreateBracketOrder function places an entry order with linked take-profit and stop-loss orders for the profile from token payload
+func (h *TradingAPIHandler) CreateBracketOrder(c echo.Context) error {
+	reqOrder := &model.CreateBracketOrder{}
+	err := c.Bind(reqOrder)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqOrder": reqOrder}).Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Bind: %v", err))
+	}
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	group, err := h.srv.CreateBracketOrder(c.Request().Context(), id, reqOrder)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id, "reqOrder": reqOrder}).Errorf("CreateBracketOrder: %v", err)
+		return tradingError("CreateBracketOrder", err)
+	}
+	return c.JSON(http.StatusOK, group)
+}
+
+// CreateOCOOrder function places one-cancels-other take-profit and stop-loss orders on an open position of the profile from token payload
+func (h *TradingAPIHandler) CreateOCOOrder(c echo.Context) error {
+	reqOrder := &model.CreateOCOOrder{}
+	err := c.Bind(reqOrder)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"reqOrder": reqOrder}).Errorf("Bind: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Bind: %v", err))
+	}
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	group, err := h.srv.CreateOCOOrder(c.Request().Context(), id, reqOrder)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id, "reqOrder": reqOrder}).Errorf("CreateOCOOrder: %v", err)
+		return tradingError("CreateOCOOrder", err)
+	}
+	return c.JSON(http.StatusOK, group)
+}
+
+// GetPendingOrders function returns pending orders of the profile from token payload
+func (h *TradingAPIHandler) GetPendingOrders(c echo.Context) error {
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	orders, err := h.srv.GetPendingOrders(c.Request().Context(), id, c.QueryParam("account"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id}).Errorf("GetPendingOrders: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPendingOrders: %v", err))
+	}
+	return c.JSON(http.StatusOK, orders)
+}
+
+// CancelOrder function cancels a pending order of the profile from token payload
+func (h *TradingAPIHandler) CancelOrder(c echo.Context) error {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"orderID": c.Param("id")}).Errorf("Parse: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Parse: %v", err))
+	}
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	order, err := h.srv.CancelOrder(c.Request().Context(), id, orderID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id, "orderID": orderID}).Errorf("CancelOrder: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("CancelOrder: %v", err))
+	}
+	return c.JSON(http.StatusOK, order)
+}
+
+// ResetPaperBalance function restores the initial virtual balance of the paper account of the profile from token payload
+func (h *TradingAPIHandler) ResetPaperBalance(c echo.Context) error {
+	id, err := middlewr.GetPayloadFromToken(strings.Split(c.Request().Header.Get("Authorization"), " ")[1])
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"Payload": strings.Split(c.Request().Header.Get("Authorization"), " ")[1]}).Errorf("GetPayloadFromToken: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("GetPayloadFromToken: %v", err))
+	}
+	balance, err := h.srv.ResetPaperBalance(c.Request().Context(), id)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"ID": id}).Errorf("ResetPaperBalance: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("ResetPaperBalance: %v", err))
+	}
+	return c.JSON(http.StatusOK, balance)
+}
+
+// tradingError returns an error with status 422 describing the violated rule if the trade is rejected by a risk check,
+// an error with status 503 if the price of the share is stale and an error with status 500 otherwise
+func tradingError(method string, err error) error {
+	var violation *model.RiskViolation
+	if errors.As(err, &violation) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, violation)
+	}
+	var staleQuote *model.StaleQuoteError
+	if errors.As(err, &staleQuote) {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, staleQuote)
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s: %v", method, err))
+}
@@ -62,6 +62,19 @@ func UserIdentity() echo.MiddlewareFunc {
 	}
 }
 
+// TokenFromQuery is a middleware function that takes the access token from the given query parameter if there is no auth header,
+// browsers cannot set headers of WebSocket and EventSource requests
+func TokenFromQuery(param string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token := c.QueryParam(param); token != "" && c.Request().Header.Get("Authorization") == "" {
+				c.Request().Header.Set("Authorization", Bearer+" "+token)
+			}
+			return next(c)
+		}
+	}
+}
+
 // ValidateToken parses tokenString and returns valid jwt token string
 func ValidateToken(tokenString, signingKey string) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
@@ -0,0 +1,15 @@
+// Package model provides data Structures
+package model
+
+import "time"
+
+// MarketStatus struct represents whether a share is tradable at the moment, opens at and closes at are the boundaries
+// of the current session if the market is open and of the next session otherwise, they are zero if the share is always tradable
+type MarketStatus struct {
+	ShareName string    `json:"share_name"`
+	Exchange  string    `json:"exchange"`
+	IsOpen    bool      `json:"is_open"`
+	OpensAt   time.Time `json:"opens_at"`
+	ClosesAt  time.Time `json:"closes_at"`
+	Timestamp time.Time `json:"timestamp"`
+}
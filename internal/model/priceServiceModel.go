@@ -1,6 +1,11 @@
 // Package model provides model structures
 package model
 
+import (
+	"fmt"
+	"time"
+)
+
 // StreamedShares represents streams
 type StreamedShares struct {
 	Share []string `json:"share"`
@@ -8,6 +13,121 @@ type StreamedShares struct {
 
 // Shares represents shares kekw
 type Shares struct {
-	ShareName  string      `json:"share_name"`
-	SharePrice interface{} `json:"price"`
+	ShareName  string  `json:"share_name"`
+	SharePrice float64 `json:"price"`
+}
+
+// Quote represents the latest known price of a share, a stale quote is too old to trade on
+type Quote struct {
+	ShareName  string    `json:"share_name"`
+	SharePrice float64   `json:"price"`
+	ReceivedAt time.Time `json:"received_at"`
+	Stale      bool      `json:"stale"`
+}
+
+// StaleQuoteError represents a refusal to trade on a stale quote because a fresh price could not be received
+type StaleQuoteError struct {
+	ShareName  string    `json:"share_name"`
+	ReceivedAt time.Time `json:"received_at"`
+	MaxAge     string    `json:"max_age"`
+}
+
+// Error method returns the description of the refusal
+func (e *StaleQuoteError) Error() string {
+	return fmt.Sprintf("quote of share %s received at %s is older than %s", e.ShareName, e.ReceivedAt.Format(time.RFC3339), e.MaxAge)
+}
+
+// UnknownShareError represents a request for a share the price service has no price of
+type UnknownShareError struct {
+	ShareName string `json:"share_name"`
+}
+
+// Error method returns the description of the request
+func (e *UnknownShareError) Error() string {
+	return fmt.Sprintf("share %s is unknown", e.ShareName)
+}
+
+// PriceTick represents a price received from the price stream, IDs grow with every received price,
+// a tick with a feed event carries no price and no ID
+type PriceTick struct {
+	ID uint64 `json:"id"`
+	Shares
+	Feed      *FeedEvent `json:"feed,omitempty"`
+	Timestamp time.Time  `json:"timestamp"`
+}
+
+// statuses of the price feed
+const (
+	FeedInterrupted = "interrupted"
+	FeedRestored    = "restored"
+)
+
+// FeedEvent represents an interruption of the price feed of shares or its restoration after reconnecting
+type FeedEvent struct {
+	Status string   `json:"status"`
+	Shares []string `json:"shares"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// actions of a client of the price stream
+const (
+	PriceActionSubscribe   = "subscribe"
+	PriceActionUnsubscribe = "unsubscribe"
+)
+
+// types of messages sent to a client of the price stream
+const (
+	PriceMessagePrice      = "price"
+	PriceMessageSubscribed = "subscribed"
+	PriceMessageError      = "error"
+	PriceMessageFeed       = "feed"
+)
+
+// PriceCommand represents a request of a client to subscribe to or unsubscribe from prices of shares
+type PriceCommand struct {
+	Action string   `json:"action"`
+	Shares []string `json:"shares"`
+}
+
+// PriceMessage represents a message sent to a client of the price stream: a price tick, the shares it is subscribed to,
+// an interruption or restoration of the feed of the shares or an error
+type PriceMessage struct {
+	ID        uint64    `json:"id,omitempty"`
+	Type      string    `json:"type"`
+	ShareName string    `json:"share_name,omitempty"`
+	Price     float64   `json:"price,omitempty"`
+	Shares    []string  `json:"shares,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Message   string    `json:"message,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// intervals of candles
+const (
+	CandleInterval1m  = "1m"
+	CandleInterval5m  = "5m"
+	CandleInterval15m = "15m"
+	CandleInterval1h  = "1h"
+	CandleInterval1d  = "1d"
+)
+
+// CandleIntervals maps intervals of candles to their durations
+var CandleIntervals = map[string]time.Duration{
+	CandleInterval1m:  time.Minute,
+	CandleInterval5m:  5 * time.Minute,
+	CandleInterval15m: 15 * time.Minute,
+	CandleInterval1h:  time.Hour,
+	CandleInterval1d:  24 * time.Hour,
+}
+
+// Candle represents prices of a share received during an interval starting at the open time, aligned to UTC
+type Candle struct {
+	ShareName string    `json:"share_name"`
+	Interval  string    `json:"interval"`
+	OpenTime  time.Time `json:"open_time"`
+	Open      float64   `json:"open"`
+	High      float64   `json:"high"`
+	Low       float64   `json:"low"`
+	Close     float64   `json:"close"`
+	Ticks     int       `json:"ticks"`
 }
@@ -0,0 +1,26 @@
+// Package model provides data Structures
+package model
+
+import "fmt"
+
+// rules of pre-trade risk checks, max slippage is set by the client on market orders
+const (
+	RiskRuleMaxNotionalPerShare  = "max_notional_per_share"
+	RiskRuleMaxExposure          = "max_exposure"
+	RiskRuleMaxOpenPositions     = "max_open_positions"
+	RiskRuleMaxOrderBalanceRatio = "max_order_balance_ratio"
+	RiskRuleMaxSlippage          = "max_slippage"
+)
+
+// RiskViolation struct represents a rejection of a trade by a pre-trade risk check
+type RiskViolation struct {
+	Rule    string  `json:"rule"`
+	Message string  `json:"message"`
+	Limit   float64 `json:"limit"`
+	Value   float64 `json:"value"`
+}
+
+// Error method returns the description of the violation
+func (v *RiskViolation) Error() string {
+	return fmt.Sprintf("risk check %s failed: %s", v.Rule, v.Message)
+}
@@ -0,0 +1,328 @@
+// Package model provides data Structures
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// accounts a position is settled against: live positions use the balance service, paper positions a virtual balance kept by trading-api
+const (
+	AccountLive  = "live"
+	AccountPaper = "paper"
+)
+
+// sides of a position
+const (
+	SideLong  = "long"
+	SideShort = "short"
+)
+
+// reasons of closing a position
+const (
+	CloseReasonManual       = "manual"
+	CloseReasonStopLoss     = "stop_loss"
+	CloseReasonTakeProfit   = "take_profit"
+	CloseReasonLiquidation  = "liquidation"
+	CloseReasonTrailingStop = "trailing_stop"
+)
+
+// Position struct represents a trading position on a share,
+// a trailing stop follows the best price reached since opening, the peak, at the trailing distance or percent,
+// the open fee is the part of the fees paid on opening that falls on the current amount, the close fee is the total paid on closing
+type Position struct {
+	ID               uuid.UUID `json:"id"`
+	ProfileID        uuid.UUID `json:"profile_id"`
+	Account          string    `json:"account"`
+	ShareName        string    `json:"share_name"`
+	Side             string    `json:"side"`
+	Amount           float64   `json:"amount"`
+	Leverage         float64   `json:"leverage"`
+	Margin           float64   `json:"margin"`
+	OpenPrice        float64   `json:"open_price"`
+	ClosePrice       float64   `json:"close_price"`
+	StopLoss         float64   `json:"stop_loss,omitempty"`
+	TakeProfit       float64   `json:"take_profit,omitempty"`
+	TrailingDistance float64   `json:"trailing_distance,omitempty"`
+	TrailingPercent  float64   `json:"trailing_percent,omitempty"`
+	TrailingPeak     float64   `json:"trailing_peak,omitempty"`
+	RealizedPnL      float64   `json:"realized_pnl"`
+	OpenFee          float64   `json:"open_fee"`
+	CloseFee         float64   `json:"close_fee"`
+	IsOpen           bool      `json:"is_open"`
+	CloseReason      string    `json:"close_reason,omitempty"`
+	OpenedAt         time.Time `json:"opened_at"`
+	ClosedAt         time.Time `json:"closed_at"`
+	ClientOrderID    string    `json:"client_order_id,omitempty"`
+}
+
+// PositionPnL struct represents an open position valued at the current price,
+// the percent P&L is relative to the margin reserved for the position
+type PositionPnL struct {
+	Position
+	CurrentPrice         float64 `json:"current_price"`
+	UnrealizedPnL        float64 `json:"unrealized_pnl"`
+	UnrealizedPnLPercent float64 `json:"unrealized_pnl_percent"`
+	TrailingStop         float64 `json:"trailing_stop,omitempty"`
+	PriceUnavailable     bool    `json:"price_unavailable,omitempty"`
+}
+
+// Portfolio struct represents a snapshot of the balance and open positions of a profile, market value is the value of long positions
+// less the value of short ones and gross market value is the value of all of them,
+// equity is the cash plus margin and unrealized P&L of the positions, free margin is the equity not used as margin
+type Portfolio struct {
+	ProfileID        uuid.UUID      `json:"profile_id"`
+	Account          string         `json:"account"`
+	Cash             float64        `json:"cash"`
+	MarketValue      float64        `json:"market_value"`
+	GrossMarketValue float64        `json:"gross_market_value"`
+	UnrealizedPnL    float64        `json:"unrealized_pnl"`
+	Equity           float64        `json:"equity"`
+	UsedMargin       float64        `json:"used_margin"`
+	FreeMargin       float64        `json:"free_margin"`
+	Positions        []*PositionPnL `json:"positions"`
+	Timestamp        time.Time      `json:"timestamp"`
+}
+
+// OpenPosition struct represents a request to open a position
+type OpenPosition struct {
+	ClientOrderID      string  `json:"client_order_id"`
+	Account            string  `json:"account"`
+	ShareName          string  `json:"share_name"`
+	Side               string  `json:"side"`
+	Amount             float64 `json:"amount"`
+	Leverage           float64 `json:"leverage"`
+	StopLoss           float64 `json:"stop_loss"`
+	TakeProfit         float64 `json:"take_profit"`
+	TrailingDistance   float64 `json:"trailing_distance"`
+	TrailingPercent    float64 `json:"trailing_percent"`
+	ReferencePrice     float64 `json:"reference_price"`
+	MaxSlippagePercent float64 `json:"max_slippage_percent"`
+}
+
+// ClosePosition struct represents a request to close a position, zero amount closes the whole position
+type ClosePosition struct {
+	ClientOrderID string    `json:"client_order_id"`
+	PositionID    uuid.UUID `json:"position_id"`
+	Amount        float64   `json:"amount"`
+}
+
+// IncreasePosition struct represents a request to add to an open position, with the same slippage protection as opening one
+type IncreasePosition struct {
+	ClientOrderID      string    `json:"client_order_id"`
+	PositionID         uuid.UUID `json:"position_id"`
+	Amount             float64   `json:"amount"`
+	ReferencePrice     float64   `json:"reference_price"`
+	MaxSlippagePercent float64   `json:"max_slippage_percent"`
+}
+
+// Liquidation struct represents a record of a position forcibly closed because of insufficient margin
+type Liquidation struct {
+	ID                uuid.UUID `json:"id"`
+	PositionID        uuid.UUID `json:"position_id"`
+	ProfileID         uuid.UUID `json:"profile_id"`
+	Account           string    `json:"account"`
+	ShareName         string    `json:"share_name"`
+	Price             float64   `json:"price"`
+	Equity            float64   `json:"equity"`
+	MaintenanceMargin float64   `json:"maintenance_margin"`
+	Reason            string    `json:"reason"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// types of an order
+const (
+	OrderTypeMarket = "market"
+	OrderTypeLimit  = "limit"
+	OrderTypeStop   = "stop"
+)
+
+// actions of an order: open orders open a new position, close orders close their position
+const (
+	OrderActionOpen  = "open"
+	OrderActionClose = "close"
+)
+
+// statuses of an order, inactive orders of a group wait for the entry order to be filled
+const (
+	OrderStatusInactive = "inactive"
+	OrderStatusPending  = "pending"
+	OrderStatusFilled   = "filled"
+	OrderStatusCanceled = "canceled"
+	OrderStatusRejected = "rejected"
+)
+
+// Order struct represents an order to open or close a position, pending orders are filled when the price reaches their trigger price,
+// orders placed together share the group ID and filling a close order of the group cancels the other ones
+type Order struct {
+	ID                 uuid.UUID `json:"id"`
+	ProfileID          uuid.UUID `json:"profile_id"`
+	Account            string    `json:"account"`
+	ShareName          string    `json:"share_name"`
+	Side               string    `json:"side"`
+	Type               string    `json:"type"`
+	Action             string    `json:"action"`
+	GroupID            uuid.UUID `json:"group_id"`
+	Amount             float64   `json:"amount"`
+	Leverage           float64   `json:"leverage"`
+	TriggerPrice       float64   `json:"trigger_price,omitempty"`
+	StopLoss           float64   `json:"stop_loss,omitempty"`
+	TakeProfit         float64   `json:"take_profit,omitempty"`
+	TrailingDistance   float64   `json:"trailing_distance,omitempty"`
+	TrailingPercent    float64   `json:"trailing_percent,omitempty"`
+	ReferencePrice     float64   `json:"reference_price,omitempty"`
+	MaxSlippagePercent float64   `json:"max_slippage_percent,omitempty"`
+	Status             string    `json:"status"`
+	FillPrice          float64   `json:"fill_price,omitempty"`
+	PositionID         uuid.UUID `json:"position_id"`
+	RejectReason       string    `json:"reject_reason,omitempty"`
+	ClientOrderID      string    `json:"client_order_id,omitempty"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+// CreateOrder struct represents a request to place an order
+type CreateOrder struct {
+	ClientOrderID      string  `json:"client_order_id"`
+	Account            string  `json:"account"`
+	ShareName          string  `json:"share_name"`
+	Side               string  `json:"side"`
+	Type               string  `json:"type"`
+	Amount             float64 `json:"amount"`
+	Leverage           float64 `json:"leverage"`
+	TriggerPrice       float64 `json:"trigger_price"`
+	StopLoss           float64 `json:"stop_loss"`
+	TakeProfit         float64 `json:"take_profit"`
+	TrailingDistance   float64 `json:"trailing_distance"`
+	TrailingPercent    float64 `json:"trailing_percent"`
+	ReferencePrice     float64 `json:"reference_price"`
+	MaxSlippagePercent float64 `json:"max_slippage_percent"`
+}
+
+// CreateBracketOrder struct represents a request to place an entry order together with take-profit and stop-loss orders
+// closing the position it opens
+type CreateBracketOrder struct {
+	ClientOrderID string      `json:"client_order_id"`
+	Entry         CreateOrder `json:"entry"`
+	TakeProfit    float64     `json:"take_profit"`
+	StopLoss      float64     `json:"stop_loss"`
+}
+
+// CreateOCOOrder struct represents a request to place one-cancels-other take-profit and stop-loss orders closing an open position,
+// zero amount closes the whole position
+type CreateOCOOrder struct {
+	ClientOrderID string    `json:"client_order_id"`
+	PositionID    uuid.UUID `json:"position_id"`
+	Amount        float64   `json:"amount"`
+	TakeProfit    float64   `json:"take_profit"`
+	StopLoss      float64   `json:"stop_loss"`
+}
+
+// OrderGroup struct represents orders placed together, the entry is empty for OCO groups
+type OrderGroup struct {
+	GroupID    uuid.UUID `json:"group_id"`
+	Entry      *Order    `json:"entry,omitempty"`
+	TakeProfit *Order    `json:"take_profit"`
+	StopLoss   *Order    `json:"stop_loss"`
+}
+
+// operations submitted with a client order ID
+const (
+	ClientRequestOpenPosition     = "open_position"
+	ClientRequestClosePosition    = "close_position"
+	ClientRequestIncreasePosition = "increase_position"
+	ClientRequestCreateOrder      = "create_order"
+	ClientRequestCreateOrderGroup = "create_order_group"
+)
+
+// statuses of a request submitted with a client order ID
+const (
+	ClientRequestPending   = "pending"
+	ClientRequestCompleted = "completed"
+)
+
+// ClientRequest struct represents a request submitted by a profile with a client order ID, a pending request is reserved
+// before it is executed and completed with the ID of the position or order it resulted in, requests saved without a status are completed
+type ClientRequest struct {
+	ID            uuid.UUID `json:"id"`
+	ProfileID     uuid.UUID `json:"profile_id"`
+	ClientOrderID string    `json:"client_order_id"`
+	Operation     string    `json:"operation"`
+	Status        string    `json:"status,omitempty"`
+	ResultID      uuid.UUID `json:"result_id"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// TradeRecord struct represents a closed position kept in the trade history,
+// net P&L is the realized P&L less the open fee of the closed amount and the close fee
+type TradeRecord struct {
+	ID          uuid.UUID `json:"id"`
+	PositionID  uuid.UUID `json:"position_id"`
+	ProfileID   uuid.UUID `json:"profile_id"`
+	Account     string    `json:"account"`
+	ShareName   string    `json:"share_name"`
+	Side        string    `json:"side"`
+	Amount      float64   `json:"amount"`
+	Leverage    float64   `json:"leverage"`
+	OpenPrice   float64   `json:"open_price"`
+	ClosePrice  float64   `json:"close_price"`
+	RealizedPnL float64   `json:"realized_pnl"`
+	OpenFee     float64   `json:"open_fee"`
+	CloseFee    float64   `json:"close_fee"`
+	NetPnL      float64   `json:"net_pnl"`
+	CloseReason string    `json:"close_reason"`
+	OpenedAt    time.Time `json:"opened_at"`
+	ClosedAt    time.Time `json:"closed_at"`
+}
+
+// statuses of a settlement
+const (
+	SettlementStatusPending = "pending"
+	SettlementStatusApplied = "applied"
+	SettlementStatusFailed  = "failed"
+)
+
+// Settlement struct represents a ledger entry of a position close applied to the balance as one operation:
+// the net amount is the released margin plus the gross P&L less the fees and moves the balance from BalanceBefore to BalanceAfter,
+// a pending settlement is retried until it is applied, a failed one found the balance changed by something else and needs reconciliation
+type Settlement struct {
+	ID            uuid.UUID `json:"id"`
+	PositionID    uuid.UUID `json:"position_id"`
+	ProfileID     uuid.UUID `json:"profile_id"`
+	Account       string    `json:"account"`
+	Margin        float64   `json:"margin"`
+	GrossPnL      float64   `json:"gross_pnl"`
+	Fees          float64   `json:"fees"`
+	NetAmount     float64   `json:"net_amount"`
+	BalanceBefore float64   `json:"balance_before"`
+	BalanceAfter  float64   `json:"balance_after"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	Error         string    `json:"error,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TradeHistoryCursor struct represents the position of the last returned trade record, records are ordered by closing time descending
+type TradeHistoryCursor struct {
+	ClosedAt time.Time
+	ID       uuid.UUID
+}
+
+// TradeHistoryFilter struct represents a query of the trade history, zero From and To mean an unbounded range
+type TradeHistoryFilter struct {
+	ProfileID uuid.UUID
+	Account   string
+	ShareName string
+	From      time.Time
+	To        time.Time
+	Cursor    *TradeHistoryCursor
+	Limit     int
+}
+
+// TradeHistory struct represents a page of the trade history
+type TradeHistory struct {
+	Records    []*TradeRecord `json:"records"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
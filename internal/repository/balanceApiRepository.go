@@ -42,12 +42,7 @@ func (r *BalanceRepository) GetBalance(ctx context.Context, id uuid.UUID) (*mode
 	if err != nil {
 		return nil, fmt.Errorf("parse: %w", err)
 	}
-	responseBalanceID, err := uuid.Parse(response.Balance.BalanceID)
-	if err != nil {
-		return nil, fmt.Errorf("parse: %w", err)
-	}
 	balance := &model.Balance{
-		BalanceID: responseBalanceID,
 		ProfileID: responseProfileID,
 		Balance:   response.Balance.Balance,
 	}
@@ -57,7 +52,6 @@ func (r *BalanceRepository) GetBalance(ctx context.Context, id uuid.UUID) (*mode
 // UpdateBalance method updates a balance
 func (r *BalanceRepository) UpdateBalance(ctx context.Context, balance *model.Balance) error {
 	protoBalance := &balanceProto.Balance{
-		BalanceID: balance.BalanceID.String(),
 		ProfileID: balance.ProfileID.String(),
 		Balance:   balance.Balance,
 	}
@@ -0,0 +1,233 @@
+// Package repository contains methods to communicate with postgres and gRPC servers
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// kvStore struct represents an embedded key-value store kept in an append-only file,
+// every change is appended to the file as a JSON line and the file is compacted when the store is opened,
+// changes are numbered in the order they are written and flushed to disk by Sync, a failed write is cut off the file
+// and the store refuses further writes if that fails too
+type kvStore struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	size    int64
+	broken  error
+	written uint64
+	syncMu  sync.Mutex
+	synced  uint64
+}
+
+// kvEntry struct represents a single change of the store, entry without value deletes the key,
+// a batch entry contains changes written together
+type kvEntry struct {
+	Bucket string          `json:"bucket"`
+	Key    string          `json:"key"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Batch  []*kvEntry      `json:"batch,omitempty"`
+}
+
+// kvPut struct represents a value to save under the key in the bucket
+type kvPut struct {
+	Bucket string
+	Key    string
+	Value  interface{}
+}
+
+// openKVStore opens the store at the given path creating the file if needed and returns its content grouped by buckets
+func openKVStore(path string) (*kvStore, map[string]map[string]json.RawMessage, error) {
+	data, err := readKVFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("readKVFile: %w", err)
+	}
+	err = compactKVFile(path, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("compactKVFile: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("OpenFile: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, fmt.Errorf("Stat: %w", err)
+	}
+	return &kvStore{path: path, file: file, size: info.Size()}, data, nil
+}
+
+// Put method saves the value under the key in the bucket and returns the number of the change
+func (s *kvStore) Put(bucket, key string, value interface{}) (uint64, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return 0, fmt.Errorf("Marshal: %w", err)
+	}
+	return s.append(&kvEntry{Bucket: bucket, Key: key, Value: raw})
+}
+
+// PutAll method saves the values as one change, so after a crash either all or none of them are restored,
+// and returns the number of the change
+func (s *kvStore) PutAll(puts ...kvPut) (uint64, error) {
+	batch := &kvEntry{Batch: make([]*kvEntry, 0, len(puts))}
+	for _, put := range puts {
+		raw, err := json.Marshal(put.Value)
+		if err != nil {
+			return 0, fmt.Errorf("Marshal: %w", err)
+		}
+		batch.Batch = append(batch.Batch, &kvEntry{Bucket: put.Bucket, Key: put.Key, Value: raw})
+	}
+	return s.append(batch)
+}
+
+// Delete method deletes the key from the bucket and returns the number of the change
+func (s *kvStore) Delete(bucket, key string) (uint64, error) {
+	return s.append(&kvEntry{Bucket: bucket, Key: key})
+}
+
+// Sync method flushes changes up to the given number to disk, changes written by concurrent writers are flushed together
+func (s *kvStore) Sync(seq uint64) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	if s.synced >= seq {
+		return nil
+	}
+	s.mu.Lock()
+	written := s.written
+	s.mu.Unlock()
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("Sync: %w", err)
+	}
+	s.synced = written
+	return nil
+}
+
+// Close method closes the store file
+func (s *kvStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// append writes the entry to the end of the file without flushing it and returns its number,
+// a partly written entry is truncated so that it does not end up in the middle of the file
+func (s *kvStore) append(entry *kvEntry) (uint64, error) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, fmt.Errorf("Marshal: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.broken != nil {
+		return 0, fmt.Errorf("store is broken: %w", s.broken)
+	}
+	n, err := s.file.Write(append(line, '\n'))
+	if err != nil {
+		if truncErr := os.Truncate(s.path, s.size); truncErr != nil {
+			s.broken = truncErr
+			logrus.Errorf("Truncate: %v", truncErr)
+		}
+		return 0, fmt.Errorf("Write: %w", err)
+	}
+	s.size += int64(n)
+	s.written++
+	return s.written, nil
+}
+
+// readKVFile replays entries of the file, an incomplete last line left by an interrupted write is ignored
+func readKVFile(path string) (map[string]map[string]json.RawMessage, error) {
+	data := make(map[string]map[string]json.RawMessage)
+	file, err := os.Open(path) // nolint:gosec
+	if errors.Is(err, os.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Open: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			logrus.Errorf("Close: %v", closeErr)
+		}
+	}()
+	reader := bufio.NewReader(file)
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			entry := &kvEntry{}
+			if err = json.Unmarshal(line, entry); err != nil {
+				if readErr == io.EOF {
+					break
+				}
+				return nil, fmt.Errorf("Unmarshal: %w", err)
+			}
+			if entry.Batch == nil {
+				applyKVEntry(data, entry)
+			}
+			for _, batchEntry := range entry.Batch {
+				applyKVEntry(data, batchEntry)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("ReadBytes: %w", readErr)
+		}
+	}
+	return data, nil
+}
+
+// applyKVEntry applies the change to the content of the store
+func applyKVEntry(data map[string]map[string]json.RawMessage, entry *kvEntry) {
+	if data[entry.Bucket] == nil {
+		data[entry.Bucket] = make(map[string]json.RawMessage)
+	}
+	if entry.Value == nil {
+		delete(data[entry.Bucket], entry.Key)
+	} else {
+		data[entry.Bucket][entry.Key] = entry.Value
+	}
+}
+
+// compactKVFile atomically replaces the file with one entry per live key
+func compactKVFile(path string, data map[string]map[string]json.RawMessage) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600) // nolint:gosec
+	if err != nil {
+		return fmt.Errorf("OpenFile: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for bucket, values := range data {
+		for key, value := range values {
+			if err = encoder.Encode(&kvEntry{Bucket: bucket, Key: key, Value: value}); err != nil {
+				_ = file.Close()
+				return fmt.Errorf("Encode: %w", err)
+			}
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("Flush: %w", err)
+	}
+	if err = file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("Sync: %w", err)
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("Close: %w", err)
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("Rename: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+// Package repository contains methods to communicate with postgres and gRPC servers
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+)
+
+// PaperBalanceRepository struct represents a repository of virtual balances of paper trading accounts,
+// balances are kept together with the trading state and a profile without one starts with the initial balance
+type PaperBalanceRepository struct {
+	rps            *TradingRepository
+	initialBalance float64
+}
+
+// NewPaperBalanceRepository creates a new PaperBalanceRepository kept in the given trading repository
+func NewPaperBalanceRepository(rps *TradingRepository, initialBalance float64) *PaperBalanceRepository {
+	return &PaperBalanceRepository{rps: rps, initialBalance: initialBalance}
+}
+
+// CreateBalance method creates a virtual balance with the initial amount
+func (r *PaperBalanceRepository) CreateBalance(ctx context.Context, profileID uuid.UUID) error {
+	return r.UpdateBalance(ctx, &model.Balance{ProfileID: profileID, Balance: r.initialBalance})
+}
+
+// GetBalance method returns a virtual balance by the given profile ID
+func (r *PaperBalanceRepository) GetBalance(_ context.Context, profileID uuid.UUID) (*model.Balance, error) {
+	r.rps.mu.RLock()
+	defer r.rps.mu.RUnlock()
+	balance, ok := r.rps.paperBalances[profileID]
+	if !ok {
+		return &model.Balance{ProfileID: profileID, Balance: r.initialBalance}, nil
+	}
+	return &balance, nil
+}
+
+// UpdateBalance method saves a virtual balance
+func (r *PaperBalanceRepository) UpdateBalance(_ context.Context, balance *model.Balance) error {
+	return r.rps.update(func() (uint64, error) {
+		seq, err := r.rps.persist(bucketPaperBalances, balance.ProfileID, balance)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.rps.paperBalances[balance.ProfileID] = *balance
+		return seq, nil
+	})
+}
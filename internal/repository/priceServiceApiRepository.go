@@ -19,22 +19,50 @@ func NewPriceServiceRepository(client priceServiceProto.PriceServiceClient) *pri
 	return &priceServiceRepo{client: client}
 }
 
-// RecvShares receives a list of selected shares
-func (r *priceServiceRepo) RecvShares(ctx context.Context, selectedShares []string) (*model.Shares, error) {
+// RecvShares receives the first message of a subscription to the selected shares and returns every share in it
+func (r *priceServiceRepo) RecvShares(ctx context.Context, selectedShares []string) ([]*model.Shares, error) {
 	req := &priceServiceProto.SubscribeRequest{
 		ShareName: selectedShares,
 	}
-	stream, err := r.client.Subscribe(ctx, req)
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := r.client.Subscribe(streamCtx, req)
 	if err != nil {
-		return nil, fmt.Errorf("Login: %w", err)
+		return nil, fmt.Errorf("Subscribe: %w", err)
 	}
 	response, err := stream.Recv()
 	if err != nil {
 		return nil, fmt.Errorf("recv: %w", err)
 	}
-	shares := &model.Shares{
-		ShareName:  response.Shares[0].ShareName,
-		SharePrice: response.Shares[0].SharePrice,
+	shares := make([]*model.Shares, 0, len(response.Shares))
+	for _, share := range response.Shares {
+		shares = append(shares, &model.Shares{
+			ShareName:  share.ShareName,
+			SharePrice: share.SharePrice,
+		})
 	}
 	return shares, nil
 }
+
+// StreamShares subscribes to the selected shares and passes every received share to handle until the stream ends
+func (r *priceServiceRepo) StreamShares(ctx context.Context, selectedShares []string, handle func(*model.Shares)) error {
+	req := &priceServiceProto.SubscribeRequest{
+		ShareName: selectedShares,
+	}
+	stream, err := r.client.Subscribe(ctx, req)
+	if err != nil {
+		return fmt.Errorf("Subscribe: %w", err)
+	}
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("recv: %w", err)
+		}
+		for _, share := range response.Shares {
+			handle(&model.Shares{
+				ShareName:  share.ShareName,
+				SharePrice: share.SharePrice,
+			})
+		}
+	}
+}
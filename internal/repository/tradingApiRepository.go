@@ -0,0 +1,477 @@
+// Package repository contains methods to communicate with postgres and gRPC servers
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// buckets of the trading state
+const (
+	bucketPositions      = "positions"
+	bucketOrders         = "orders"
+	bucketLiquidations   = "liquidations"
+	bucketHistory        = "history"
+	bucketClientRequests = "client_requests"
+	bucketPaperBalances  = "paper_balances"
+	bucketSettlements    = "settlements"
+)
+
+// TradingRepository struct represents a storage of trading positions and orders,
+// the state is kept in memory and, if the repository is backed by a store, every change is written to it first
+// and reported once it is flushed to disk, open positions are indexed to be listed without scanning closed ones
+type TradingRepository struct {
+	mu             sync.RWMutex
+	store          *kvStore
+	positions      map[uuid.UUID]model.Position
+	openPositions  map[uuid.UUID]struct{}
+	orders         map[uuid.UUID]model.Order
+	liquidations   map[uuid.UUID]model.Liquidation
+	history        map[uuid.UUID]model.TradeRecord
+	clientRequests map[uuid.UUID]model.ClientRequest
+	paperBalances  map[uuid.UUID]model.Balance
+	settlements    map[uuid.UUID]model.Settlement
+}
+
+// NewTradingRepository creates a new in-memory TradingRepository
+func NewTradingRepository() *TradingRepository {
+	return &TradingRepository{
+		positions:      make(map[uuid.UUID]model.Position),
+		openPositions:  make(map[uuid.UUID]struct{}),
+		orders:         make(map[uuid.UUID]model.Order),
+		liquidations:   make(map[uuid.UUID]model.Liquidation),
+		history:        make(map[uuid.UUID]model.TradeRecord),
+		clientRequests: make(map[uuid.UUID]model.ClientRequest),
+		paperBalances:  make(map[uuid.UUID]model.Balance),
+		settlements:    make(map[uuid.UUID]model.Settlement),
+	}
+}
+
+// persist writes the value to the store if there is one and returns the number of the change to commit, nil value deletes the key
+func (r *TradingRepository) persist(bucket string, key uuid.UUID, value interface{}) (uint64, error) {
+	if r.store == nil {
+		return 0, nil
+	}
+	if value == nil {
+		return r.store.Delete(bucket, key.String())
+	}
+	return r.store.Put(bucket, key.String(), value)
+}
+
+// update applies the change under the write lock and waits until it is flushed to disk after releasing the lock,
+// so changes of different profiles do not wait for the disk one after another, a failed flush is fatal
+// because the change is already visible in memory and the state has to be restored from the file
+func (r *TradingRepository) update(change func() (uint64, error)) error {
+	r.mu.Lock()
+	seq, err := change()
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if r.store == nil || seq == 0 {
+		return nil
+	}
+	if err = r.store.Sync(seq); err != nil {
+		logrus.Fatalf("Sync: %v", err)
+	}
+	return nil
+}
+
+// setPosition saves the position in memory and keeps the index of open positions, lock must be held
+func (r *TradingRepository) setPosition(position *model.Position) {
+	r.positions[position.ID] = *position
+	if position.IsOpen {
+		r.openPositions[position.ID] = struct{}{}
+	} else {
+		delete(r.openPositions, position.ID)
+	}
+}
+
+// CreatePosition method saves a new position
+func (r *TradingRepository) CreatePosition(_ context.Context, position *model.Position) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.positions[position.ID]; ok {
+			return 0, fmt.Errorf("position %s already exists", position.ID)
+		}
+		seq, err := r.persist(bucketPositions, position.ID, position)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.setPosition(position)
+		return seq, nil
+	})
+}
+
+// GetPositionByID method returns a position by the given ID
+func (r *TradingRepository) GetPositionByID(_ context.Context, id uuid.UUID) (*model.Position, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	position, ok := r.positions[id]
+	if !ok {
+		return nil, fmt.Errorf("position %s not found", id)
+	}
+	return &position, nil
+}
+
+// UpdatePosition method updates an existing position
+func (r *TradingRepository) UpdatePosition(_ context.Context, position *model.Position) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.positions[position.ID]; !ok {
+			return 0, fmt.Errorf("position %s not found", position.ID)
+		}
+		seq, err := r.persist(bucketPositions, position.ID, position)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.setPosition(position)
+		return seq, nil
+	})
+}
+
+// DeletePosition method deletes a position by the given ID
+func (r *TradingRepository) DeletePosition(_ context.Context, id uuid.UUID) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.positions[id]; !ok {
+			return 0, fmt.Errorf("position %s not found", id)
+		}
+		seq, err := r.persist(bucketPositions, id, nil)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		delete(r.positions, id)
+		delete(r.openPositions, id)
+		return seq, nil
+	})
+}
+
+// GetOpenPositions method returns all open positions
+func (r *TradingRepository) GetOpenPositions(_ context.Context) ([]*model.Position, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	positions := make([]*model.Position, 0, len(r.openPositions))
+	for id := range r.openPositions {
+		position := r.positions[id]
+		positions = append(positions, &position)
+	}
+	return positions, nil
+}
+
+// CreateLiquidation method saves a liquidation record
+func (r *TradingRepository) CreateLiquidation(_ context.Context, liquidation *model.Liquidation) error {
+	return r.update(func() (uint64, error) {
+		seq, err := r.persist(bucketLiquidations, liquidation.ID, liquidation)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.liquidations[liquidation.ID] = *liquidation
+		return seq, nil
+	})
+}
+
+// GetLiquidationsByProfileID method returns liquidation records of the given profile, newest first
+func (r *TradingRepository) GetLiquidationsByProfileID(_ context.Context, profileID uuid.UUID) ([]*model.Liquidation, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	liquidations := make([]*model.Liquidation, 0)
+	for id := range r.liquidations {
+		liquidation := r.liquidations[id]
+		if liquidation.ProfileID == profileID {
+			liquidations = append(liquidations, &liquidation)
+		}
+	}
+	sort.Slice(liquidations, func(i, j int) bool {
+		return liquidations[i].CreatedAt.After(liquidations[j].CreatedAt)
+	})
+	return liquidations, nil
+}
+
+// CreateOrder method saves a new order
+func (r *TradingRepository) CreateOrder(_ context.Context, order *model.Order) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.orders[order.ID]; ok {
+			return 0, fmt.Errorf("order %s already exists", order.ID)
+		}
+		seq, err := r.persist(bucketOrders, order.ID, order)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.orders[order.ID] = *order
+		return seq, nil
+	})
+}
+
+// GetOrderByID method returns an order by the given ID
+func (r *TradingRepository) GetOrderByID(_ context.Context, id uuid.UUID) (*model.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	order, ok := r.orders[id]
+	if !ok {
+		return nil, fmt.Errorf("order %s not found", id)
+	}
+	return &order, nil
+}
+
+// UpdateOrder method updates an existing order
+func (r *TradingRepository) UpdateOrder(_ context.Context, order *model.Order) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.orders[order.ID]; !ok {
+			return 0, fmt.Errorf("order %s not found", order.ID)
+		}
+		seq, err := r.persist(bucketOrders, order.ID, order)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.orders[order.ID] = *order
+		return seq, nil
+	})
+}
+
+// GetPendingOrders method returns all pending orders sorted by creation time
+func (r *TradingRepository) GetPendingOrders(_ context.Context) ([]*model.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	orders := make([]*model.Order, 0)
+	for id := range r.orders {
+		order := r.orders[id]
+		if order.Status == model.OrderStatusPending {
+			orders = append(orders, &order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	return orders, nil
+}
+
+// GetOrdersByGroupID method returns orders of the given group sorted by creation time
+func (r *TradingRepository) GetOrdersByGroupID(_ context.Context, groupID uuid.UUID) ([]*model.Order, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	orders := make([]*model.Order, 0)
+	for id := range r.orders {
+		order := r.orders[id]
+		if order.GroupID == groupID {
+			orders = append(orders, &order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+	})
+	return orders, nil
+}
+
+// CreateTradeRecord method saves a closed position to the trade history
+func (r *TradingRepository) CreateTradeRecord(_ context.Context, record *model.TradeRecord) error {
+	return r.update(func() (uint64, error) {
+		seq, err := r.persist(bucketHistory, record.ID, record)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.history[record.ID] = *record
+		return seq, nil
+	})
+}
+
+// GetTradeHistory method returns up to filter.Limit trade records matching the filter ordered by closing time descending
+func (r *TradingRepository) GetTradeHistory(_ context.Context, filter *model.TradeHistoryFilter) ([]*model.TradeRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	records := make([]*model.TradeRecord, 0)
+	for id := range r.history {
+		record := r.history[id]
+		if matchesTradeHistoryFilter(&record, filter) {
+			records = append(records, &record)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return tradeHistoryLess(records[i].ClosedAt, records[i].ID, records[j].ClosedAt, records[j].ID)
+	})
+	if len(records) > filter.Limit {
+		records = records[:filter.Limit]
+	}
+	return records, nil
+}
+
+// CreateClientRequest method saves a new request submitted with a client order ID
+func (r *TradingRepository) CreateClientRequest(_ context.Context, req *model.ClientRequest) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.clientRequests[req.ID]; ok {
+			return 0, fmt.Errorf("client request %s already exists", req.ID)
+		}
+		seq, err := r.persist(bucketClientRequests, req.ID, req)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.clientRequests[req.ID] = *req
+		return seq, nil
+	})
+}
+
+// UpdateClientRequest method saves changes of an existing request submitted with a client order ID
+func (r *TradingRepository) UpdateClientRequest(_ context.Context, req *model.ClientRequest) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.clientRequests[req.ID]; !ok {
+			return 0, fmt.Errorf("client request %s not found", req.ID)
+		}
+		seq, err := r.persist(bucketClientRequests, req.ID, req)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.clientRequests[req.ID] = *req
+		return seq, nil
+	})
+}
+
+// DeleteClientRequest method deletes a request submitted with a client order ID by the given ID
+func (r *TradingRepository) DeleteClientRequest(_ context.Context, id uuid.UUID) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.clientRequests[id]; !ok {
+			return 0, fmt.Errorf("client request %s not found", id)
+		}
+		seq, err := r.persist(bucketClientRequests, id, nil)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		delete(r.clientRequests, id)
+		return seq, nil
+	})
+}
+
+// GetClientRequest method returns a request submitted with a client order ID by the given ID or nil if there is none
+func (r *TradingRepository) GetClientRequest(_ context.Context, id uuid.UUID) (*model.ClientRequest, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	req, ok := r.clientRequests[id]
+	if !ok {
+		return nil, nil
+	}
+	return &req, nil
+}
+
+// UpdateSettlement method saves changes of an existing settlement
+func (r *TradingRepository) UpdateSettlement(_ context.Context, settlement *model.Settlement) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.settlements[settlement.ID]; !ok {
+			return 0, fmt.Errorf("settlement %s not found", settlement.ID)
+		}
+		seq, err := r.persist(bucketSettlements, settlement.ID, settlement)
+		if err != nil {
+			return 0, fmt.Errorf("persist: %w", err)
+		}
+		r.settlements[settlement.ID] = *settlement
+		return seq, nil
+	})
+}
+
+// UpdatePositionWithSettlement method saves changes of an existing position together with a new settlement of them as one change
+func (r *TradingRepository) UpdatePositionWithSettlement(_ context.Context, position *model.Position, settlement *model.Settlement) error {
+	return r.update(func() (uint64, error) {
+		if _, ok := r.positions[position.ID]; !ok {
+			return 0, fmt.Errorf("position %s not found", position.ID)
+		}
+		if _, ok := r.settlements[settlement.ID]; ok {
+			return 0, fmt.Errorf("settlement %s already exists", settlement.ID)
+		}
+		var seq uint64
+		if r.store != nil {
+			var err error
+			seq, err = r.store.PutAll(
+				kvPut{Bucket: bucketPositions, Key: position.ID.String(), Value: position},
+				kvPut{Bucket: bucketSettlements, Key: settlement.ID.String(), Value: settlement},
+			)
+			if err != nil {
+				return 0, fmt.Errorf("PutAll: %w", err)
+			}
+		}
+		r.setPosition(position)
+		r.settlements[settlement.ID] = *settlement
+		return seq, nil
+	})
+}
+
+// GetSettlementByID method returns a settlement by the given ID
+func (r *TradingRepository) GetSettlementByID(_ context.Context, id uuid.UUID) (*model.Settlement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	settlement, ok := r.settlements[id]
+	if !ok {
+		return nil, fmt.Errorf("settlement %s not found", id)
+	}
+	return &settlement, nil
+}
+
+// GetPendingSettlements method returns settlements not applied yet, oldest first
+func (r *TradingRepository) GetPendingSettlements(_ context.Context) ([]*model.Settlement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	settlements := make([]*model.Settlement, 0)
+	for id := range r.settlements {
+		settlement := r.settlements[id]
+		if settlement.Status == model.SettlementStatusPending {
+			settlements = append(settlements, &settlement)
+		}
+	}
+	sort.Slice(settlements, func(i, j int) bool {
+		return settlements[i].CreatedAt.Before(settlements[j].CreatedAt)
+	})
+	return settlements, nil
+}
+
+// GetSettlementsByProfileID method returns settlements of the given profile, newest first
+func (r *TradingRepository) GetSettlementsByProfileID(_ context.Context, profileID uuid.UUID) ([]*model.Settlement, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	settlements := make([]*model.Settlement, 0)
+	for id := range r.settlements {
+		settlement := r.settlements[id]
+		if settlement.ProfileID == profileID {
+			settlements = append(settlements, &settlement)
+		}
+	}
+	sort.Slice(settlements, func(i, j int) bool {
+		return settlements[i].CreatedAt.After(settlements[j].CreatedAt)
+	})
+	return settlements, nil
+}
+
+// matchesTradeHistoryFilter reports whether the record matches the filter and lies after its cursor
+func matchesTradeHistoryFilter(record *model.TradeRecord, filter *model.TradeHistoryFilter) bool {
+	if record.ProfileID != filter.ProfileID {
+		return false
+	}
+	account := record.Account
+	if account == "" {
+		account = model.AccountLive
+	}
+	if filter.Account != "" && account != filter.Account {
+		return false
+	}
+	if filter.ShareName != "" && record.ShareName != filter.ShareName {
+		return false
+	}
+	if !filter.From.IsZero() && record.ClosedAt.Before(filter.From) {
+		return false
+	}
+	if !filter.To.IsZero() && record.ClosedAt.After(filter.To) {
+		return false
+	}
+	if filter.Cursor != nil && !tradeHistoryLess(filter.Cursor.ClosedAt, filter.Cursor.ID, record.ClosedAt, record.ID) {
+		return false
+	}
+	return true
+}
+
+// tradeHistoryLess reports whether the record a goes before the record b in the trade history: newer records go first, ties are ordered by ID
+func tradeHistoryLess(closedAtA time.Time, idA uuid.UUID, closedAtB time.Time, idB uuid.UUID) bool {
+	if !closedAtA.Equal(closedAtB) {
+		return closedAtA.After(closedAtB)
+	}
+	return idA.String() < idB.String()
+}
@@ -0,0 +1,64 @@
+// Package repository contains methods to communicate with postgres and gRPC servers
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// NewTradingStoreRepository creates a new TradingRepository backed by the key-value file at the given path
+// and restores the state saved in it, so positions and orders survive restarts
+func NewTradingStoreRepository(path string) (*TradingRepository, error) {
+	store, data, err := openKVStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("openKVStore: %w", err)
+	}
+	r := NewTradingRepository()
+	r.store = store
+	restorers := map[string]func(map[string]json.RawMessage) error{
+		bucketPositions:      func(raw map[string]json.RawMessage) error { return restoreBucket(raw, r.positions) },
+		bucketOrders:         func(raw map[string]json.RawMessage) error { return restoreBucket(raw, r.orders) },
+		bucketLiquidations:   func(raw map[string]json.RawMessage) error { return restoreBucket(raw, r.liquidations) },
+		bucketHistory:        func(raw map[string]json.RawMessage) error { return restoreBucket(raw, r.history) },
+		bucketClientRequests: func(raw map[string]json.RawMessage) error { return restoreBucket(raw, r.clientRequests) },
+		bucketPaperBalances:  func(raw map[string]json.RawMessage) error { return restoreBucket(raw, r.paperBalances) },
+		bucketSettlements:    func(raw map[string]json.RawMessage) error { return restoreBucket(raw, r.settlements) },
+	}
+	for bucket, restore := range restorers {
+		if err = restore(data[bucket]); err != nil {
+			_ = store.Close()
+			return nil, fmt.Errorf("restore %s: %w", bucket, err)
+		}
+	}
+	for id := range r.positions {
+		position := r.positions[id]
+		r.setPosition(&position)
+	}
+	return r, nil
+}
+
+// Close method closes the store backing the repository
+func (r *TradingRepository) Close() error {
+	if r.store == nil {
+		return nil
+	}
+	return r.store.Close()
+}
+
+// restoreBucket decodes values of the bucket into the map keyed by IDs
+func restoreBucket[T any](raw map[string]json.RawMessage, dst map[uuid.UUID]T) error {
+	for key, value := range raw {
+		id, err := uuid.Parse(key)
+		if err != nil {
+			return fmt.Errorf("Parse: %w", err)
+		}
+		var item T
+		if err = json.Unmarshal(value, &item); err != nil {
+			return fmt.Errorf("Unmarshal: %w", err)
+		}
+		dst[id] = item
+	}
+	return nil
+}
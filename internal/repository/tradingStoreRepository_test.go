@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTradingStoreRepositoryRestoresState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trading.db")
+	ctx := context.Background()
+
+	rps, err := NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	position := &model.Position{ID: uuid.New(), ProfileID: uuid.New(), ShareName: "Apple", Amount: 1, IsOpen: true, OpenedAt: time.Now()}
+	require.NoError(t, rps.CreatePosition(ctx, position))
+	deleted := &model.Position{ID: uuid.New(), ProfileID: position.ProfileID, ShareName: "Apple", IsOpen: true}
+	require.NoError(t, rps.CreatePosition(ctx, deleted))
+	require.NoError(t, rps.DeletePosition(ctx, deleted.ID))
+	order := &model.Order{ID: uuid.New(), ProfileID: position.ProfileID, ShareName: "Apple", Status: model.OrderStatusPending}
+	require.NoError(t, rps.CreateOrder(ctx, order))
+	order.Status = model.OrderStatusCanceled
+	require.NoError(t, rps.UpdateOrder(ctx, order))
+	require.NoError(t, rps.Close())
+
+	rps, err = NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	restored, err := rps.GetPositionByID(ctx, position.ID)
+	require.NoError(t, err)
+	require.Equal(t, position.ShareName, restored.ShareName)
+	require.True(t, restored.OpenedAt.Equal(position.OpenedAt))
+	_, err = rps.GetPositionByID(ctx, deleted.ID)
+	require.Error(t, err)
+	restoredOrder, err := rps.GetOrderByID(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusCanceled, restoredOrder.Status)
+	require.NoError(t, rps.Close())
+}
+
+func TestTradingStoreRepositoryIgnoresIncompleteLastLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trading.db")
+	ctx := context.Background()
+
+	rps, err := NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	position := &model.Position{ID: uuid.New(), ShareName: "Apple", IsOpen: true}
+	require.NoError(t, rps.CreatePosition(ctx, position))
+	require.NoError(t, rps.Close())
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"bucket":"positions","key":"`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	rps, err = NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	positions, err := rps.GetOpenPositions(ctx)
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	require.NoError(t, rps.Close())
+}
+
+func TestTradingStoreRepositoryIndexesOpenPositions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trading.db")
+	ctx := context.Background()
+
+	rps, err := NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	positions := make([]*model.Position, 20)
+	for i := range positions {
+		positions[i] = &model.Position{ID: uuid.New(), ProfileID: uuid.New(), ShareName: "Apple", IsOpen: true}
+		wg.Add(1)
+		go func(position *model.Position) {
+			defer wg.Done()
+			require.NoError(t, rps.CreatePosition(ctx, position))
+		}(positions[i])
+	}
+	wg.Wait()
+	closed := *positions[0]
+	closed.IsOpen = false
+	require.NoError(t, rps.UpdatePosition(ctx, &closed))
+	require.NoError(t, rps.DeletePosition(ctx, positions[1].ID))
+	open, err := rps.GetOpenPositions(ctx)
+	require.NoError(t, err)
+	require.Len(t, open, 18)
+	require.NoError(t, rps.Close())
+
+	rps, err = NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	open, err = rps.GetOpenPositions(ctx)
+	require.NoError(t, err)
+	require.Len(t, open, 18, "the index is rebuilt from the restored positions")
+	require.NoError(t, rps.Close())
+}
+
+func TestTradingStoreRepositorySavesPositionWithSettlement(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trading.db")
+	ctx := context.Background()
+
+	rps, err := NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	position := &model.Position{ID: uuid.New(), ProfileID: uuid.New(), ShareName: "Apple", Amount: 1, IsOpen: true}
+	require.NoError(t, rps.CreatePosition(ctx, position))
+	position.IsOpen = false
+	settlement := &model.Settlement{ID: uuid.New(), PositionID: position.ID, ProfileID: position.ProfileID, NetAmount: 100,
+		Status: model.SettlementStatusPending}
+	require.NoError(t, rps.UpdatePositionWithSettlement(ctx, position, settlement))
+	require.Error(t, rps.UpdatePositionWithSettlement(ctx, position, settlement), "settlement is saved once")
+	require.NoError(t, rps.Close())
+
+	rps, err = NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	restored, err := rps.GetPositionByID(ctx, position.ID)
+	require.NoError(t, err)
+	require.False(t, restored.IsOpen)
+	pending, err := rps.GetPendingSettlements(ctx)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, settlement.ID, pending[0].ID)
+	require.NoError(t, rps.Close())
+}
+
+func TestTradingStoreRepositoryTruncatesFailedWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trading.db")
+	ctx := context.Background()
+
+	rps, err := NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	file := rps.store.file
+	rps.store.file, err = os.Open(path) // nolint:gosec
+	require.NoError(t, err)
+	failed := &model.Position{ID: uuid.New(), ShareName: "Apple", IsOpen: true}
+	require.Error(t, rps.CreatePosition(ctx, failed))
+	_, err = rps.GetPositionByID(ctx, failed.ID)
+	require.Error(t, err, "failed change is not applied in memory")
+	require.NoError(t, rps.store.file.Close())
+	rps.store.file = file
+
+	position := &model.Position{ID: uuid.New(), ShareName: "Apple", IsOpen: true}
+	require.NoError(t, rps.CreatePosition(ctx, position))
+	require.NoError(t, rps.Close())
+
+	rps, err = NewTradingStoreRepository(path)
+	require.NoError(t, err)
+	positions, err := rps.GetOpenPositions(ctx)
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	require.Equal(t, position.ID, positions[0].ID)
+	require.NoError(t, rps.Close())
+}
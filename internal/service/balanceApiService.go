@@ -3,6 +3,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/eugenshima/trading-api/internal/model"
@@ -10,6 +11,9 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+// errBalanceChanged is returned when the balance is neither the one before nor after the settlement
+var errBalanceChanged = errors.New("balance changed since the settlement was made")
+
 // BalanceService struct ....
 type BalanceService struct {
 	balanceRps BalanceRepository
@@ -66,6 +70,29 @@ func (s *BalanceService) WithdrawMoney(ctx context.Context, balance *model.Balan
 	return dbBalance.Balance, nil
 }
 
+// ApplySettlement method sets the balance from the one before the settlement to the one after it, so it can be repeated
+// until it succeeds: a balance already equal to the one after it is left as it is and any other balance is not touched,
+// trading and balance operations of the profile wait for its pending settlements so that the balance does not change under them
+func (s *BalanceService) ApplySettlement(ctx context.Context, settlement *model.Settlement) error {
+	dbBalance, err := s.balanceRps.GetBalance(ctx, settlement.ProfileID)
+	if err != nil {
+		return fmt.Errorf("GetBalance: %w", err)
+	}
+	switch dbBalance.Balance {
+	case settlement.BalanceAfter:
+		return nil
+	case settlement.BalanceBefore:
+	default:
+		return fmt.Errorf("%w: expected %v, got %v", errBalanceChanged, settlement.BalanceBefore, dbBalance.Balance)
+	}
+	dbBalance.Balance = settlement.BalanceAfter
+	err = s.balanceRps.UpdateBalance(ctx, dbBalance)
+	if err != nil {
+		return fmt.Errorf("UpdateBalance: %w", err)
+	}
+	return nil
+}
+
 func (s *BalanceService) CreateBalance(ctx context.Context, profileID uuid.UUID) error {
 	err := s.balanceRps.CreateBalance(ctx, profileID)
 	if err != nil {
@@ -0,0 +1,193 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+)
+
+// CandleAggregator struct represents a consumer of the price stream aggregating prices of tracked shares into candles of every interval,
+// a share is tracked from the start if configured and otherwise from the first request of its candles if the price service knows it,
+// such a share gets a subscription of its own so that tracking it leaves the streams of already tracked shares open
+type CandleAggregator struct {
+	subscriptions *PriceSubscriptionManager
+	priceRps      PriceServiceRepository
+	historyLen    int
+	mu            sync.RWMutex
+	subs          []*PriceSubscription
+	closed        bool
+	tracked       map[string]struct{}
+	candles       map[string]map[string][]*model.Candle
+}
+
+// NewCandleAggregator creates a new CandleAggregator tracking the given shares and keeping the given number of candles per interval
+func NewCandleAggregator(subscriptions *PriceSubscriptionManager, priceRps PriceServiceRepository, shares []string,
+	historyLen int) *CandleAggregator {
+	a := &CandleAggregator{
+		subscriptions: subscriptions,
+		priceRps:      priceRps,
+		historyLen:    historyLen,
+		tracked:       make(map[string]struct{}),
+		candles:       make(map[string]map[string][]*model.Candle),
+	}
+	for _, share := range shares {
+		a.tracked[share] = struct{}{}
+	}
+	if len(shares) > 0 {
+		a.subscribe(shares)
+	}
+	return a
+}
+
+// Run method aggregates received prices until ctx is done
+func (a *CandleAggregator) Run(ctx context.Context) {
+	<-ctx.Done()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	for _, sub := range a.subs {
+		sub.Close()
+	}
+}
+
+// subscribe aggregates prices of the shares received on a new subscription until it is closed, a.mu must be held once the aggregator is shared
+func (a *CandleAggregator) subscribe(shares []string) {
+	sub := a.subscriptions.Subscribe(shares)
+	a.subs = append(a.subs, sub)
+	go func() {
+		for tick := range sub.C {
+			a.Add(tick)
+		}
+	}()
+}
+
+// Add method adds the price to the current candle of every interval of its share, a price of a new interval opens a new candle,
+// feed events are ignored
+func (a *CandleAggregator) Add(tick *model.PriceTick) {
+	if tick.Feed != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	intervals, ok := a.candles[tick.ShareName]
+	if !ok {
+		intervals = make(map[string][]*model.Candle, len(model.CandleIntervals))
+		a.candles[tick.ShareName] = intervals
+	}
+	for interval, duration := range model.CandleIntervals {
+		openTime := tick.Timestamp.UTC().Truncate(duration)
+		candles := intervals[interval]
+		if n := len(candles); n > 0 && !candles[n-1].OpenTime.Before(openTime) {
+			updateCandle(candles[n-1], tick.SharePrice)
+			continue
+		}
+		if a.historyLen > 0 && len(candles) == a.historyLen {
+			copy(candles, candles[1:])
+			candles = candles[:len(candles)-1]
+		}
+		intervals[interval] = append(candles, &model.Candle{
+			ShareName: tick.ShareName,
+			Interval:  interval,
+			OpenTime:  openTime,
+			Open:      tick.SharePrice,
+			High:      tick.SharePrice,
+			Low:       tick.SharePrice,
+			Close:     tick.SharePrice,
+			Ticks:     1,
+		})
+	}
+}
+
+// GetCandles method returns candles of the share of the given interval opened in the range from inclusive to exclusive, oldest first,
+// zero from and to mean an unbounded range, a share that is not tracked yet is tracked from now on,
+// a share unknown to the price service is refused with *model.UnknownShareError
+func (a *CandleAggregator) GetCandles(ctx context.Context, shareName, interval string, from, to time.Time) ([]*model.Candle, error) {
+	if shareName == "" {
+		return nil, fmt.Errorf("share name is empty")
+	}
+	if _, ok := model.CandleIntervals[interval]; !ok {
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+	err := a.track(ctx, shareName)
+	if err != nil {
+		return nil, fmt.Errorf("track: %w", err)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	candles := a.candles[shareName][interval]
+	start := 0
+	if !from.IsZero() {
+		start = sort.Search(len(candles), func(i int) bool {
+			return !candles[i].OpenTime.Before(from)
+		})
+	}
+	result := make([]*model.Candle, 0)
+	for _, candle := range candles[start:] {
+		if !to.IsZero() && !candle.OpenTime.Before(to) {
+			break
+		}
+		copied := *candle
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// track adds the share to the shares whose prices are aggregated if the price service has a price of it
+func (a *CandleAggregator) track(ctx context.Context, shareName string) error {
+	a.mu.RLock()
+	_, ok := a.tracked[shareName]
+	a.mu.RUnlock()
+	if ok {
+		return nil
+	}
+	known, err := a.isKnownShare(ctx, shareName)
+	if err != nil {
+		return fmt.Errorf("isKnownShare: %w", err)
+	}
+	if !known {
+		return &model.UnknownShareError{ShareName: shareName}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok = a.tracked[shareName]; ok {
+		return nil
+	}
+	a.tracked[shareName] = struct{}{}
+	if !a.closed {
+		a.subscribe([]string{shareName})
+	}
+	return nil
+}
+
+// isKnownShare reports whether the price service returns a price of the share
+func (a *CandleAggregator) isKnownShare(ctx context.Context, shareName string) (bool, error) {
+	shares, err := a.priceRps.RecvShares(ctx, []string{shareName})
+	if err != nil {
+		return false, fmt.Errorf("RecvShares: %w", err)
+	}
+	for _, share := range shares {
+		if share.ShareName == shareName && share.SharePrice > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// updateCandle adds the price to the candle
+func updateCandle(candle *model.Candle, price float64) {
+	if price > candle.High {
+		candle.High = price
+	}
+	if price < candle.Low {
+		candle.Low = price
+	}
+	candle.Close = price
+	candle.Ticks++
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCandleAggregatorBuildsCandlesOfEveryInterval(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares)}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, time.Millisecond, 10)
+	defer manager.Close()
+	aggregator := NewCandleAggregator(manager, &fakePriceRepository{prices: map[string]float64{"Apple": 100}}, nil, 2)
+	ctx := context.Background()
+
+	start := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+	for i, price := range []float64{100, 104, 98, 101, 107} {
+		aggregator.Add(&model.PriceTick{
+			Shares:    model.Shares{ShareName: "Apple", SharePrice: price},
+			Timestamp: start.Add(time.Duration(i) * 20 * time.Second),
+		})
+	}
+	candles, err := aggregator.GetCandles(ctx, "Apple", model.CandleInterval1m, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, candles, 2)
+	require.Equal(t, model.Candle{ShareName: "Apple", Interval: model.CandleInterval1m, OpenTime: start,
+		Open: 100, High: 104, Low: 98, Close: 98, Ticks: 3}, *candles[0])
+	require.Equal(t, 107.0, candles[1].Close)
+
+	candles, err = aggregator.GetCandles(ctx, "Apple", model.CandleInterval1m, start.Add(time.Minute), time.Time{})
+	require.NoError(t, err)
+	require.Len(t, candles, 1)
+
+	candles, err = aggregator.GetCandles(ctx, "Apple", model.CandleInterval1d, time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, candles, 1)
+	require.Equal(t, 5, candles[0].Ticks)
+
+	_, err = aggregator.GetCandles(ctx, "Apple", "2m", time.Time{}, time.Time{})
+	require.Error(t, err)
+	require.Eventually(t, func() bool { return streamRps.openStreams("Apple") == 1 }, time.Second, time.Millisecond,
+		"requested share is tracked from the price stream")
+}
+
+func TestCandleAggregatorTracksKnownSharesOnly(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares)}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, time.Millisecond, 10)
+	defer manager.Close()
+	priceRps := &fakePriceRepository{prices: map[string]float64{"Tesla": 200}}
+	aggregator := NewCandleAggregator(manager, priceRps, []string{"Apple"}, 10)
+	ctx := context.Background()
+
+	candles, err := aggregator.GetCandles(ctx, "Apple", model.CandleInterval1m, time.Time{}, time.Time{})
+	require.NoError(t, err, "configured share is tracked without asking the price service")
+	require.Empty(t, candles)
+
+	for i := 0; i < 3; i++ {
+		_, err = aggregator.GetCandles(ctx, "NoSuchShare", model.CandleInterval1m, time.Time{}, time.Time{})
+		var unknownShare *model.UnknownShareError
+		require.ErrorAs(t, err, &unknownShare)
+		require.Equal(t, "NoSuchShare", unknownShare.ShareName)
+	}
+	require.Len(t, aggregator.subs, 1, "unknown share is not tracked")
+
+	candles, err = aggregator.GetCandles(ctx, "Tesla", model.CandleInterval1m, time.Time{}, time.Time{})
+	require.NoError(t, err, "untracked share known to the price service is tracked from now on")
+	require.Empty(t, candles)
+	require.Eventually(t, func() bool {
+		return streamRps.openStreams("Apple") == 1 && streamRps.openStreams("Tesla") == 1
+	}, time.Second, time.Millisecond, "stream of the configured share is kept open")
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		aggregator.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	<-done
+	require.Eventually(t, func() bool {
+		return streamRps.openStreams("Apple") == 0 && streamRps.openStreams("Tesla") == 0
+	}, time.Second, time.Millisecond)
+}
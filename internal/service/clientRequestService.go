@@ -0,0 +1,113 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// maxClientOrderIDLength limits the length of a client order ID
+const maxClientOrderIDLength = 64
+
+// findClientRequest returns the completed request submitted by the profile with the client order ID or nil if there is none,
+// reusing the ID for another operation or a request whose result is unknown is an error, profile lock must be held
+func (s *TradingService) findClientRequest(ctx context.Context, profileID uuid.UUID, clientOrderID, operation string) (*model.ClientRequest, error) {
+	if clientOrderID == "" {
+		return nil, nil
+	}
+	if len(clientOrderID) > maxClientOrderIDLength {
+		return nil, fmt.Errorf("client order ID must not be longer than %d characters", maxClientOrderIDLength)
+	}
+	req, err := s.tradingRps.GetClientRequest(ctx, clientRequestID(profileID, clientOrderID))
+	if err != nil {
+		return nil, fmt.Errorf("GetClientRequest: %w", err)
+	}
+	if req != nil && req.Operation != operation {
+		return nil, fmt.Errorf("client order ID %q is already used by %s", clientOrderID, req.Operation)
+	}
+	if req != nil && req.Status == model.ClientRequestPending {
+		return nil, fmt.Errorf("result of the request with client order ID %q is unknown", clientOrderID)
+	}
+	return req, nil
+}
+
+// reserveClientRequest saves the request submitted with the client order ID as pending before it is executed,
+// so it is never executed twice, profile lock must be held
+func (s *TradingService) reserveClientRequest(ctx context.Context, profileID uuid.UUID, clientOrderID, operation string) error {
+	if clientOrderID == "" {
+		return nil
+	}
+	return s.tradingRps.CreateClientRequest(ctx, &model.ClientRequest{
+		ID:            clientRequestID(profileID, clientOrderID),
+		ProfileID:     profileID,
+		ClientOrderID: clientOrderID,
+		Operation:     operation,
+		Status:        model.ClientRequestPending,
+		CreatedAt:     time.Now(),
+	})
+}
+
+// releaseClientRequest deletes the reservation of a request that failed, so it can be retried, profile lock must be held
+func (s *TradingService) releaseClientRequest(ctx context.Context, profileID uuid.UUID, clientOrderID string) {
+	if clientOrderID == "" {
+		return
+	}
+	if err := s.tradingRps.DeleteClientRequest(ctx, clientRequestID(profileID, clientOrderID)); err != nil {
+		logrus.WithFields(logrus.Fields{"clientOrderID": clientOrderID}).Errorf("DeleteClientRequest: %v", err)
+	}
+}
+
+// releaseUnsavedOrder releases the reservation of a request whose order could not be saved unless the order has already been filled,
+// the reservation of a filled order is kept so that a retry does not open its position again, profile lock must be held
+func (s *TradingService) releaseUnsavedOrder(ctx context.Context, profileID uuid.UUID, clientOrderID string, order *model.Order) {
+	if order.Status == model.OrderStatusFilled {
+		logrus.WithFields(logrus.Fields{"order": order}).Error("filled order is not saved, its client order ID stays reserved")
+		return
+	}
+	s.releaseClientRequest(ctx, profileID, clientOrderID)
+}
+
+// saveClientRequest completes the reserved request submitted with the client order ID with its result, profile lock must be held
+func (s *TradingService) saveClientRequest(ctx context.Context, profileID uuid.UUID, clientOrderID string, resultID uuid.UUID) error {
+	if clientOrderID == "" {
+		return nil
+	}
+	req, err := s.tradingRps.GetClientRequest(ctx, clientRequestID(profileID, clientOrderID))
+	if err != nil {
+		return fmt.Errorf("GetClientRequest: %w", err)
+	}
+	if req == nil {
+		return fmt.Errorf("request with client order ID %q is not reserved", clientOrderID)
+	}
+	req.Status = model.ClientRequestCompleted
+	req.ResultID = resultID
+	return s.tradingRps.UpdateClientRequest(ctx, req)
+}
+
+// replayPosition returns the current state of the position the completed request resulted in
+func (s *TradingService) replayPosition(ctx context.Context, req *model.ClientRequest) (*model.Position, error) {
+	position, err := s.tradingRps.GetPositionByID(ctx, req.ResultID)
+	if err != nil {
+		return nil, fmt.Errorf("GetPositionByID: %w", err)
+	}
+	return position, nil
+}
+
+// replayOrder returns the current state of the order the completed request resulted in
+func (s *TradingService) replayOrder(ctx context.Context, req *model.ClientRequest) (*model.Order, error) {
+	order, err := s.tradingRps.GetOrderByID(ctx, req.ResultID)
+	if err != nil {
+		return nil, fmt.Errorf("GetOrderByID: %w", err)
+	}
+	return order, nil
+}
+
+// clientRequestID derives the ID of a request from the profile and the client order ID, so IDs of different profiles never collide
+func clientRequestID(profileID uuid.UUID, clientOrderID string) uuid.UUID {
+	return uuid.NewSHA1(profileID, []byte(clientOrderID))
+}
@@ -0,0 +1,237 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/config"
+	"github.com/eugenshima/trading-api/internal/model"
+)
+
+// formats of the market calendar
+const (
+	calendarTimeLayout = "15:04"
+	calendarDateLayout = "2006-01-02"
+)
+
+// sessionLookahead limits how many days ahead the next session is looked for
+const sessionLookahead = 31
+
+// MarketCalendar struct represents trading hours of exchanges shares are traded on, an empty calendar is always open
+type MarketCalendar struct {
+	defaultExchange string
+	exchanges       map[string]*exchangeSessions
+	shares          map[string]string
+}
+
+// exchangeSessions struct represents the parsed calendar of an exchange, times are minutes since midnight
+type exchangeSessions struct {
+	location *time.Location
+	open     int
+	close    int
+	weekdays map[time.Weekday]bool
+	holidays map[string]bool
+	halfDays map[string]int
+}
+
+// NewMarketCalendar creates a new MarketCalendar from its configuration, nil configuration creates a calendar that is always open
+func NewMarketCalendar(cfg *config.MarketCalendar) (*MarketCalendar, error) {
+	calendar := &MarketCalendar{exchanges: make(map[string]*exchangeSessions), shares: make(map[string]string)}
+	if cfg == nil {
+		return calendar, nil
+	}
+	for name, exchangeCfg := range cfg.Exchanges {
+		exchange, err := parseExchangeCalendar(&exchangeCfg)
+		if err != nil {
+			return nil, fmt.Errorf("exchange %s: %w", name, err)
+		}
+		calendar.exchanges[name] = exchange
+	}
+	if _, ok := calendar.exchanges[cfg.DefaultExchange]; cfg.DefaultExchange != "" && !ok {
+		return nil, fmt.Errorf("unknown default exchange %s", cfg.DefaultExchange)
+	}
+	for share, exchange := range cfg.Shares {
+		if _, ok := calendar.exchanges[exchange]; !ok {
+			return nil, fmt.Errorf("unknown exchange %s of share %s", exchange, share)
+		}
+		calendar.shares[share] = exchange
+	}
+	calendar.defaultExchange = cfg.DefaultExchange
+	return calendar, nil
+}
+
+// LoadMarketCalendar function creates a MarketCalendar from the file at the given path, empty path creates a calendar that is always open
+func LoadMarketCalendar(path string) (*MarketCalendar, error) {
+	if path == "" {
+		return NewMarketCalendar(nil)
+	}
+	cfg, err := config.LoadMarketCalendar(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadMarketCalendar: %w", err)
+	}
+	return NewMarketCalendar(cfg)
+}
+
+// Status method returns whether the share is tradable at the given time and the boundaries of the current or next session
+func (c *MarketCalendar) Status(shareName string, now time.Time) *model.MarketStatus {
+	status := &model.MarketStatus{ShareName: shareName, IsOpen: true, Timestamp: now}
+	name, ok := c.shares[shareName]
+	if !ok {
+		name = c.defaultExchange
+	}
+	exchange, ok := c.exchanges[name]
+	if !ok {
+		return status
+	}
+	status.Exchange = name
+	status.IsOpen = false
+	local := now.In(exchange.location)
+	for day := 0; day <= sessionLookahead; day++ {
+		date := time.Date(local.Year(), local.Month(), local.Day()+day, 0, 0, 0, 0, exchange.location)
+		opensAt, closesAt, ok := exchange.session(date)
+		if !ok || !local.Before(closesAt) {
+			continue
+		}
+		status.IsOpen = !local.Before(opensAt)
+		status.OpensAt = opensAt
+		status.ClosesAt = closesAt
+		return status
+	}
+	return status
+}
+
+// IsOpen method reports whether the share is tradable at the given time
+func (c *MarketCalendar) IsOpen(shareName string, now time.Time) bool {
+	return c.Status(shareName, now).IsOpen
+}
+
+// session returns the opening and closing time of the session on the given date, there is none on holidays and days off
+func (e *exchangeSessions) session(date time.Time) (opensAt, closesAt time.Time, ok bool) {
+	key := date.Format(calendarDateLayout)
+	if !e.weekdays[date.Weekday()] || e.holidays[key] {
+		return time.Time{}, time.Time{}, false
+	}
+	closeMinutes := e.close
+	if halfDay, isHalfDay := e.halfDays[key]; isHalfDay {
+		closeMinutes = halfDay
+	}
+	opensAt = time.Date(date.Year(), date.Month(), date.Day(), e.open/60, e.open%60, 0, 0, e.location)
+	closesAt = time.Date(date.Year(), date.Month(), date.Day(), closeMinutes/60, closeMinutes%60, 0, 0, e.location)
+	return opensAt, closesAt, true
+}
+
+// parseExchangeCalendar checks the calendar of an exchange and converts it into sessions
+func parseExchangeCalendar(cfg *config.ExchangeCalendar) (*exchangeSessions, error) {
+	location, err := time.LoadLocation(cfg.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("LoadLocation: %w", err)
+	}
+	exchange := &exchangeSessions{
+		location: location,
+		weekdays: make(map[time.Weekday]bool),
+		holidays: make(map[string]bool),
+		halfDays: make(map[string]int),
+	}
+	if exchange.open, err = parseCalendarTime(cfg.Open); err != nil {
+		return nil, fmt.Errorf("parseCalendarTime(open): %w", err)
+	}
+	if exchange.close, err = parseCalendarTime(cfg.Close); err != nil {
+		return nil, fmt.Errorf("parseCalendarTime(close): %w", err)
+	}
+	if exchange.close <= exchange.open {
+		return nil, fmt.Errorf("close %s must be after open %s", cfg.Close, cfg.Open)
+	}
+	weekdays := cfg.Weekdays
+	if len(weekdays) == 0 {
+		weekdays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday"}
+	}
+	for _, weekday := range weekdays {
+		day, ok := parseWeekday(weekday)
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", weekday)
+		}
+		exchange.weekdays[day] = true
+	}
+	for _, holiday := range cfg.Holidays {
+		if _, err = time.Parse(calendarDateLayout, holiday); err != nil {
+			return nil, fmt.Errorf("Parse(holiday): %w", err)
+		}
+		exchange.holidays[holiday] = true
+	}
+	for date, closeTime := range cfg.HalfDays {
+		if _, err = time.Parse(calendarDateLayout, date); err != nil {
+			return nil, fmt.Errorf("Parse(half day): %w", err)
+		}
+		var closeMinutes int
+		if closeMinutes, err = parseCalendarTime(closeTime); err != nil {
+			return nil, fmt.Errorf("parseCalendarTime(half day): %w", err)
+		}
+		if closeMinutes <= exchange.open {
+			return nil, fmt.Errorf("half day close %s must be after open %s", closeTime, cfg.Open)
+		}
+		exchange.halfDays[date] = closeMinutes
+	}
+	return exchange, nil
+}
+
+// parseCalendarTime converts time of the day into minutes since midnight
+func parseCalendarTime(value string) (int, error) {
+	t, err := time.Parse(calendarTimeLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("Parse: %w", err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// parseWeekday converts the full or short English name of a weekday
+func parseWeekday(value string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if value == day.String() || value == day.String()[:3] {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// GetMarketStatus method returns whether the share is currently tradable
+func (s *TradingService) GetMarketStatus(_ context.Context, shareName string) (*model.MarketStatus, error) {
+	if shareName == "" {
+		return nil, fmt.Errorf("share name is required")
+	}
+	return s.calendar.Status(shareName, time.Now()), nil
+}
+
+// checkMarketOpen returns an error if the market of the share is closed
+func (s *TradingService) checkMarketOpen(shareName string) error {
+	status := s.calendar.Status(shareName, time.Now())
+	if status.IsOpen {
+		return nil
+	}
+	if status.OpensAt.IsZero() {
+		return fmt.Errorf("market %s of share %s is closed", status.Exchange, shareName)
+	}
+	return fmt.Errorf("market %s of share %s is closed until %s", status.Exchange, shareName, status.OpensAt.Format(time.RFC3339))
+}
+
+// marketOrderPrice returns the price a market order on the share is filled at, zero price means the market is closed
+// and the order is queued until it opens, profile lock must be held
+func (s *TradingService) marketOrderPrice(ctx context.Context, shareName string) (float64, error) {
+	err := s.checkMarketOpen(shareName)
+	if err != nil {
+		if s.cfg.MarketClosedOrders == config.MarketClosedQueue {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("checkMarketOpen: %w", err)
+	}
+	err = s.checkFeed(shareName)
+	if err != nil {
+		return 0, fmt.Errorf("checkFeed: %w", err)
+	}
+	price, err := s.getSharePrice(ctx, shareName)
+	if err != nil {
+		return 0, fmt.Errorf("getSharePrice: %w", err)
+	}
+	return price, nil
+}
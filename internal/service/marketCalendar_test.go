@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/config"
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarketCalendar(t *testing.T) {
+	calendar, err := NewMarketCalendar(&config.MarketCalendar{
+		Exchanges: map[string]config.ExchangeCalendar{
+			"NYSE": {
+				TimeZone: "America/New_York",
+				Open:     "09:30",
+				Close:    "16:00",
+				Holidays: []string{"2026-07-03"},
+				HalfDays: map[string]string{"2026-11-27": "13:00"},
+			},
+		},
+		Shares: map[string]string{"Apple": "NYSE"},
+	})
+	require.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	status := calendar.Status("Apple", time.Date(2026, 7, 2, 10, 0, 0, 0, newYork))
+	require.True(t, status.IsOpen)
+	require.Equal(t, time.Date(2026, 7, 2, 16, 0, 0, 0, newYork), status.ClosesAt)
+
+	status = calendar.Status("Apple", time.Date(2026, 7, 2, 17, 0, 0, 0, newYork))
+	require.False(t, status.IsOpen)
+	require.Equal(t, time.Date(2026, 7, 6, 9, 30, 0, 0, newYork), status.OpensAt, "holiday and weekend are skipped")
+
+	status = calendar.Status("Apple", time.Date(2026, 11, 27, 14, 0, 0, 0, newYork))
+	require.False(t, status.IsOpen, "half day closes early")
+
+	require.True(t, calendar.IsOpen("Tesla", time.Date(2026, 7, 4, 12, 0, 0, 0, newYork)), "shares without an exchange are always tradable")
+}
+
+func TestMarketClosedOrders(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+
+	closed, err := NewMarketCalendar(&config.MarketCalendar{
+		DefaultExchange: "NYSE",
+		Exchanges: map[string]config.ExchangeCalendar{
+			"NYSE": {TimeZone: "UTC", Open: "00:00", Close: "00:01", Weekdays: []string{"Sun"}},
+		},
+	})
+	require.NoError(t, err)
+	srv.calendar = closed
+
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.Error(t, err)
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.Error(t, err)
+	_, err = srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Amount: 1})
+	require.Error(t, err)
+
+	srv.cfg.MarketClosedOrders = config.MarketClosedQueue
+	order, err := srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusPending, order.Status)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 100})
+	order, err = srv.tradingRps.GetOrderByID(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusPending, order.Status, "queued order waits for the market to open")
+
+	srv.calendar, err = NewMarketCalendar(nil)
+	require.NoError(t, err)
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 100})
+	order, err = srv.tradingRps.GetOrderByID(ctx, order.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusFilled, order.Status)
+	require.Equal(t, 800.0, balanceRps.balances[profileID])
+
+	srv.calendar = closed
+	bracket, err := srv.CreateBracketOrder(ctx, profileID, &model.CreateBracketOrder{
+		Entry: model.CreateOrder{ShareName: "Apple", Amount: 1}, TakeProfit: 120, StopLoss: 90})
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusPending, bracket.Entry.Status)
+	srv.calendar, err = NewMarketCalendar(nil)
+	require.NoError(t, err)
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 85})
+	group, err := srv.tradingRps.GetOrdersByGroupID(ctx, bracket.GroupID)
+	require.NoError(t, err)
+	for _, groupOrder := range group {
+		if groupOrder.ID == bracket.Entry.ID {
+			require.Equal(t, model.OrderStatusRejected, groupOrder.Status, "stop-loss above the fill price rejects the entry")
+		} else {
+			require.Equal(t, model.OrderStatusCanceled, groupOrder.Status)
+		}
+	}
+	require.Equal(t, 800.0, balanceRps.balances[profileID])
+}
+
+func TestPositionsAreNotClosedAutomaticallyWhileMarketIsClosed(t *testing.T) {
+	srv, _, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+	stopped, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, StopLoss: 90})
+	require.NoError(t, err)
+	leveraged, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 5, Leverage: 10})
+	require.NoError(t, err)
+
+	srv.calendar, err = NewMarketCalendar(&config.MarketCalendar{
+		DefaultExchange: "NYSE",
+		Exchanges: map[string]config.ExchangeCalendar{
+			"NYSE": {TimeZone: "UTC", Open: "00:00", Close: "00:01", Weekdays: []string{"Sun"}},
+		},
+	})
+	require.NoError(t, err)
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 80})
+	for _, id := range []uuid.UUID{stopped.ID, leveraged.ID} {
+		position, getErr := srv.tradingRps.GetPositionByID(ctx, id)
+		require.NoError(t, getErr)
+		require.True(t, position.IsOpen, "stop-loss and liquidation wait for the market to open")
+	}
+	require.Error(t, srv.closeTriggered(ctx, profileID, stopped.ID, 80, model.CloseReasonStopLoss))
+	require.Error(t, srv.liquidate(ctx, profileID, leveraged.ID, 80))
+
+	srv.calendar, err = NewMarketCalendar(nil)
+	require.NoError(t, err)
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 80})
+	closedStop, err := srv.tradingRps.GetPositionByID(ctx, stopped.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.CloseReasonStopLoss, closedStop.CloseReason)
+	liquidated, err := srv.tradingRps.GetPositionByID(ctx, leveraged.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.CloseReasonLiquidation, liquidated.CloseReason)
+}
@@ -0,0 +1,300 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateOrder method places an order: market orders are filled immediately, limit and stop orders are stored as pending,
+// market orders placed while the market is closed are rejected or stored as pending until it opens, depending on the configuration
+func (s *TradingService) CreateOrder(ctx context.Context, profileID uuid.UUID, req *model.CreateOrder) (*model.Order, error) {
+	openReq, err := s.validateCreateOrder(req)
+	if err != nil {
+		return nil, fmt.Errorf("validateCreateOrder: %w", err)
+	}
+
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	clientReq, err := s.findClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestCreateOrder)
+	if err != nil {
+		return nil, fmt.Errorf("findClientRequest: %w", err)
+	}
+	if clientReq != nil {
+		return s.replayOrder(ctx, clientReq)
+	}
+	var price float64
+	if req.Type == model.OrderTypeMarket {
+		price, err = s.marketOrderPrice(ctx, req.ShareName)
+		if err != nil {
+			return nil, fmt.Errorf("marketOrderPrice: %w", err)
+		}
+	}
+	err = s.reserveClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestCreateOrder)
+	if err != nil {
+		return nil, fmt.Errorf("reserveClientRequest: %w", err)
+	}
+	order, err := s.placeOrder(ctx, profileID, req, openReq, price)
+	if err != nil {
+		s.releaseClientRequest(ctx, profileID, req.ClientOrderID)
+		return nil, fmt.Errorf("placeOrder: %w", err)
+	}
+	err = s.tradingRps.CreateOrder(ctx, order)
+	if err != nil {
+		s.releaseUnsavedOrder(ctx, profileID, req.ClientOrderID, order)
+		return nil, fmt.Errorf("CreateOrder: %w", err)
+	}
+	err = s.saveClientRequest(ctx, profileID, req.ClientOrderID, order.ID)
+	if err != nil {
+		return nil, fmt.Errorf("saveClientRequest: %w", err)
+	}
+	return order, nil
+}
+
+// validateCreateOrder checks the request to place an order, fills in the default type and returns the request to open its position
+func (s *TradingService) validateCreateOrder(req *model.CreateOrder) (*model.OpenPosition, error) {
+	if req.Type == "" {
+		req.Type = model.OrderTypeMarket
+	}
+	openReq := orderOpenPosition(req)
+	err := s.validateOpenPosition(openReq)
+	if err != nil {
+		return nil, fmt.Errorf("validateOpenPosition: %w", err)
+	}
+	switch req.Type {
+	case model.OrderTypeMarket:
+		req.TriggerPrice = 0
+	case model.OrderTypeLimit, model.OrderTypeStop:
+		if req.MaxSlippagePercent > 0 {
+			return nil, fmt.Errorf("max slippage applies to market orders only")
+		}
+		if req.TriggerPrice <= 0 {
+			return nil, fmt.Errorf("trigger price must be positive, got %v", req.TriggerPrice)
+		}
+		err = validateLevels(openReq.Side, req.TriggerPrice, openReq.StopLoss, openReq.TakeProfit)
+		if err != nil {
+			return nil, fmt.Errorf("validateLevels: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown order type %q", req.Type)
+	}
+	return openReq, nil
+}
+
+// placeOrder creates an order to open a position for the validated request, market orders are filled at the given price
+// and the other ones are left pending, zero price queues a market order, the order is not saved, profile lock must be held
+func (s *TradingService) placeOrder(ctx context.Context, profileID uuid.UUID, req *model.CreateOrder, openReq *model.OpenPosition, price float64) (*model.Order, error) {
+	now := time.Now()
+	order := &model.Order{
+		ID:                 uuid.New(),
+		ProfileID:          profileID,
+		Account:            openReq.Account,
+		ShareName:          openReq.ShareName,
+		Side:               openReq.Side,
+		Type:               req.Type,
+		Action:             model.OrderActionOpen,
+		Amount:             openReq.Amount,
+		Leverage:           openReq.Leverage,
+		TriggerPrice:       req.TriggerPrice,
+		StopLoss:           openReq.StopLoss,
+		TakeProfit:         openReq.TakeProfit,
+		TrailingDistance:   openReq.TrailingDistance,
+		TrailingPercent:    openReq.TrailingPercent,
+		ReferencePrice:     openReq.ReferencePrice,
+		MaxSlippagePercent: openReq.MaxSlippagePercent,
+		Status:             model.OrderStatusPending,
+		ClientOrderID:      req.ClientOrderID,
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if req.Type != model.OrderTypeMarket || price == 0 {
+		return order, nil
+	}
+	position, err := s.openPosition(ctx, profileID, openReq, price)
+	if err != nil {
+		return nil, fmt.Errorf("openPosition: %w", err)
+	}
+	order.Status = model.OrderStatusFilled
+	order.FillPrice = price
+	order.PositionID = position.ID
+	return order, nil
+}
+
+// GetPendingOrders method returns pending orders of the profile
+func (s *TradingService) GetPendingOrders(ctx context.Context, profileID uuid.UUID, account string) ([]*model.Order, error) {
+	account, err := validateAccount(account)
+	if err != nil {
+		return nil, fmt.Errorf("validateAccount: %w", err)
+	}
+	orders, err := s.tradingRps.GetPendingOrders(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetPendingOrders: %w", err)
+	}
+	profileOrders := make([]*model.Order, 0)
+	for _, order := range orders {
+		if order.ProfileID == profileID && isAccount(order.Account, account) {
+			profileOrders = append(profileOrders, order)
+		}
+	}
+	return profileOrders, nil
+}
+
+// CancelOrder method cancels a pending or inactive order of the given profile, canceling the entry order of a group cancels its children
+func (s *TradingService) CancelOrder(ctx context.Context, profileID, orderID uuid.UUID) (*model.Order, error) {
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	order, err := s.tradingRps.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("GetOrderByID: %w", err)
+	}
+	if order.ProfileID != profileID {
+		return nil, fmt.Errorf("order %s not found", orderID)
+	}
+	if order.Status != model.OrderStatusPending && order.Status != model.OrderStatusInactive {
+		return nil, fmt.Errorf("order %s is %s", orderID, order.Status)
+	}
+	order.Status = model.OrderStatusCanceled
+	order.UpdatedAt = time.Now()
+	err = s.tradingRps.UpdateOrder(ctx, order)
+	if err != nil {
+		return nil, fmt.Errorf("UpdateOrder: %w", err)
+	}
+	if order.GroupID != uuid.Nil && order.Action != model.OrderActionClose {
+		s.updateGroup(ctx, order)
+	}
+	return order, nil
+}
+
+// matchOrders fills pending orders on the share whose trigger price is reached by its price, orders are not filled while its market is closed
+func (s *TradingService) matchOrders(ctx context.Context, share *model.Shares) {
+	if !s.calendar.IsOpen(share.ShareName, time.Now()) {
+		return
+	}
+	orders, err := s.tradingRps.GetPendingOrders(ctx)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"share": share}).Errorf("GetPendingOrders: %v", err)
+		return
+	}
+	for _, order := range orders {
+		if order.ShareName != share.ShareName || !isOrderTriggered(order, share.SharePrice) {
+			continue
+		}
+		err = s.fillOrder(ctx, order.ProfileID, order.ID, share.SharePrice)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"order": order, "share": share}).Errorf("fillOrder: %v", err)
+		}
+	}
+}
+
+// fillOrder opens or closes a position for the order at the given price if it is still pending and updates the rest of its group,
+// the order is rejected if the position cannot be opened or closed
+func (s *TradingService) fillOrder(ctx context.Context, profileID, orderID uuid.UUID, price float64) error {
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	order, err := s.tradingRps.GetOrderByID(ctx, orderID)
+	if err != nil {
+		return fmt.Errorf("GetOrderByID: %w", err)
+	}
+	if order.Status != model.OrderStatusPending {
+		return nil
+	}
+	if order.Action == model.OrderActionClose {
+		err = s.fillCloseOrder(ctx, order, price)
+	} else {
+		err = s.fillOpenOrder(ctx, order, price)
+	}
+	if err != nil {
+		order.Status = model.OrderStatusRejected
+		order.RejectReason = err.Error()
+	} else {
+		order.Status = model.OrderStatusFilled
+		order.FillPrice = price
+	}
+	order.UpdatedAt = time.Now()
+	err = s.tradingRps.UpdateOrder(ctx, order)
+	if err != nil {
+		return fmt.Errorf("UpdateOrder: %w", err)
+	}
+	if order.GroupID != uuid.Nil {
+		s.updateGroup(ctx, order)
+	}
+	logrus.WithFields(logrus.Fields{"order": order}).Info("order processed")
+	return nil
+}
+
+// fillOpenOrder opens a position for the order at the given price, profile lock must be held
+func (s *TradingService) fillOpenOrder(ctx context.Context, order *model.Order, price float64) error {
+	if order.GroupID != uuid.Nil {
+		if err := s.validateGroupLevels(ctx, order, price); err != nil {
+			return fmt.Errorf("validateGroupLevels: %w", err)
+		}
+	}
+	position, err := s.openPosition(ctx, order.ProfileID, &model.OpenPosition{
+		Account:            order.Account,
+		ShareName:          order.ShareName,
+		Side:               order.Side,
+		Amount:             order.Amount,
+		Leverage:           order.Leverage,
+		StopLoss:           order.StopLoss,
+		TakeProfit:         order.TakeProfit,
+		TrailingDistance:   order.TrailingDistance,
+		TrailingPercent:    order.TrailingPercent,
+		ReferencePrice:     order.ReferencePrice,
+		MaxSlippagePercent: order.MaxSlippagePercent,
+	}, price)
+	if err != nil {
+		return fmt.Errorf("openPosition: %w", err)
+	}
+	order.PositionID = position.ID
+	return nil
+}
+
+// isOrderTriggered reports whether the pending order should be filled at the given price:
+// queued market orders are filled at any price, limit orders buy at or below and sell at or above the trigger,
+// stop orders the other way round, open orders buy for long positions and close orders sell them
+func isOrderTriggered(order *model.Order, price float64) bool {
+	buy := order.Side == model.SideLong
+	if order.Action == model.OrderActionClose {
+		buy = !buy
+	}
+	switch order.Type {
+	case model.OrderTypeMarket:
+		return true
+	case model.OrderTypeLimit:
+		if buy {
+			return price <= order.TriggerPrice
+		}
+		return price >= order.TriggerPrice
+	case model.OrderTypeStop:
+		if buy {
+			return price >= order.TriggerPrice
+		}
+		return price <= order.TriggerPrice
+	}
+	return false
+}
+
+// orderOpenPosition converts the order request into a request to open a position
+func orderOpenPosition(req *model.CreateOrder) *model.OpenPosition {
+	return &model.OpenPosition{
+		Account:            req.Account,
+		ShareName:          req.ShareName,
+		Side:               req.Side,
+		Amount:             req.Amount,
+		Leverage:           req.Leverage,
+		StopLoss:           req.StopLoss,
+		TakeProfit:         req.TakeProfit,
+		TrailingDistance:   req.TrailingDistance,
+		TrailingPercent:    req.TrailingPercent,
+		ReferencePrice:     req.ReferencePrice,
+		MaxSlippagePercent: req.MaxSlippagePercent,
+	}
+}
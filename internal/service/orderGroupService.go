@@ -0,0 +1,287 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// CreateBracketOrder method places an entry order together with take-profit and stop-loss orders closing its position:
+// the children stay inactive until the entry is filled and filling one of them cancels the other,
+// an entry whose fill price is not between the take-profit and stop-loss is rejected together with them
+func (s *TradingService) CreateBracketOrder(ctx context.Context, profileID uuid.UUID, req *model.CreateBracketOrder) (*model.OrderGroup, error) {
+	openReq, err := s.validateCreateOrder(&req.Entry)
+	if err != nil {
+		return nil, fmt.Errorf("validateCreateOrder: %w", err)
+	}
+	if req.TakeProfit <= 0 || req.StopLoss <= 0 {
+		return nil, fmt.Errorf("take-profit and stop-loss must be positive")
+	}
+
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	clientReq, err := s.findClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestCreateOrderGroup)
+	if err != nil {
+		return nil, fmt.Errorf("findClientRequest: %w", err)
+	}
+	if clientReq != nil {
+		return s.replayOrderGroup(ctx, clientReq)
+	}
+	price := req.Entry.TriggerPrice
+	if req.Entry.Type == model.OrderTypeMarket {
+		price, err = s.marketOrderPrice(ctx, req.Entry.ShareName)
+		if err != nil {
+			return nil, fmt.Errorf("marketOrderPrice: %w", err)
+		}
+	}
+	if price > 0 {
+		err = validateLevels(openReq.Side, price, req.StopLoss, req.TakeProfit)
+		if err != nil {
+			return nil, fmt.Errorf("validateLevels: %w", err)
+		}
+	}
+	err = s.reserveClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestCreateOrderGroup)
+	if err != nil {
+		return nil, fmt.Errorf("reserveClientRequest: %w", err)
+	}
+	entry, err := s.placeOrder(ctx, profileID, &req.Entry, openReq, price)
+	if err != nil {
+		s.releaseClientRequest(ctx, profileID, req.ClientOrderID)
+		return nil, fmt.Errorf("placeOrder: %w", err)
+	}
+	group := &model.OrderGroup{GroupID: uuid.New(), Entry: entry}
+	entry.GroupID = group.GroupID
+	status := model.OrderStatusInactive
+	if entry.Status == model.OrderStatusFilled {
+		status = model.OrderStatusPending
+	}
+	group.TakeProfit = newCloseOrder(entry, group.GroupID, model.OrderTypeLimit, req.TakeProfit, status)
+	group.StopLoss = newCloseOrder(entry, group.GroupID, model.OrderTypeStop, req.StopLoss, status)
+	err = s.createOrderGroup(ctx, group)
+	if err != nil {
+		s.releaseUnsavedOrder(ctx, profileID, req.ClientOrderID, entry)
+		return nil, fmt.Errorf("createOrderGroup: %w", err)
+	}
+	err = s.saveClientRequest(ctx, profileID, req.ClientOrderID, group.GroupID)
+	if err != nil {
+		return nil, fmt.Errorf("saveClientRequest: %w", err)
+	}
+	return group, nil
+}
+
+// CreateOCOOrder method places take-profit and stop-loss orders closing an open position, filling one of them cancels the other
+func (s *TradingService) CreateOCOOrder(ctx context.Context, profileID uuid.UUID, req *model.CreateOCOOrder) (*model.OrderGroup, error) {
+	if req.TakeProfit <= 0 || req.StopLoss <= 0 {
+		return nil, fmt.Errorf("take-profit and stop-loss must be positive")
+	}
+	if req.Amount < 0 {
+		return nil, fmt.Errorf("amount must not be negative, got %v", req.Amount)
+	}
+
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	clientReq, err := s.findClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestCreateOrderGroup)
+	if err != nil {
+		return nil, fmt.Errorf("findClientRequest: %w", err)
+	}
+	if clientReq != nil {
+		return s.replayOrderGroup(ctx, clientReq)
+	}
+	position, err := s.getOpenPosition(ctx, profileID, req.PositionID)
+	if err != nil {
+		return nil, fmt.Errorf("getOpenPosition: %w", err)
+	}
+	price, err := s.getSharePrice(ctx, position.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("getSharePrice: %w", err)
+	}
+	err = validateLevels(position.Side, price, req.StopLoss, req.TakeProfit)
+	if err != nil {
+		return nil, fmt.Errorf("validateLevels: %w", err)
+	}
+	now := time.Now()
+	target := &model.Order{
+		ProfileID:  profileID,
+		Account:    position.Account,
+		ShareName:  position.ShareName,
+		Side:       position.Side,
+		Amount:     position.Amount,
+		Leverage:   position.Leverage,
+		PositionID: position.ID,
+		CreatedAt:  now,
+	}
+	if req.Amount > 0 {
+		target.Amount = req.Amount
+	}
+	group := &model.OrderGroup{GroupID: uuid.New()}
+	group.TakeProfit = newCloseOrder(target, group.GroupID, model.OrderTypeLimit, req.TakeProfit, model.OrderStatusPending)
+	group.StopLoss = newCloseOrder(target, group.GroupID, model.OrderTypeStop, req.StopLoss, model.OrderStatusPending)
+	err = s.reserveClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestCreateOrderGroup)
+	if err != nil {
+		return nil, fmt.Errorf("reserveClientRequest: %w", err)
+	}
+	err = s.createOrderGroup(ctx, group)
+	if err != nil {
+		s.releaseClientRequest(ctx, profileID, req.ClientOrderID)
+		return nil, fmt.Errorf("createOrderGroup: %w", err)
+	}
+	err = s.saveClientRequest(ctx, profileID, req.ClientOrderID, group.GroupID)
+	if err != nil {
+		return nil, fmt.Errorf("saveClientRequest: %w", err)
+	}
+	return group, nil
+}
+
+// createOrderGroup saves orders of the group
+func (s *TradingService) createOrderGroup(ctx context.Context, group *model.OrderGroup) error {
+	for _, order := range []*model.Order{group.Entry, group.TakeProfit, group.StopLoss} {
+		if order == nil {
+			continue
+		}
+		if err := s.tradingRps.CreateOrder(ctx, order); err != nil {
+			return fmt.Errorf("CreateOrder: %w", err)
+		}
+	}
+	return nil
+}
+
+// replayOrderGroup returns the current state of the order group the completed request resulted in
+func (s *TradingService) replayOrderGroup(ctx context.Context, req *model.ClientRequest) (*model.OrderGroup, error) {
+	orders, err := s.tradingRps.GetOrdersByGroupID(ctx, req.ResultID)
+	if err != nil {
+		return nil, fmt.Errorf("GetOrdersByGroupID: %w", err)
+	}
+	group := &model.OrderGroup{GroupID: req.ResultID}
+	for _, order := range orders {
+		switch {
+		case order.Action != model.OrderActionClose:
+			group.Entry = order
+		case order.Type == model.OrderTypeLimit:
+			group.TakeProfit = order
+		default:
+			group.StopLoss = order
+		}
+	}
+	return group, nil
+}
+
+// validateGroupLevels checks that the take-profit and stop-loss of the group of the entry order suit the price it is filled at,
+// profile lock must be held
+func (s *TradingService) validateGroupLevels(ctx context.Context, entry *model.Order, price float64) error {
+	orders, err := s.tradingRps.GetOrdersByGroupID(ctx, entry.GroupID)
+	if err != nil {
+		return fmt.Errorf("GetOrdersByGroupID: %w", err)
+	}
+	var stopLoss, takeProfit float64
+	for _, order := range orders {
+		switch {
+		case order.Action != model.OrderActionClose:
+		case order.Type == model.OrderTypeLimit:
+			takeProfit = order.TriggerPrice
+		default:
+			stopLoss = order.TriggerPrice
+		}
+	}
+	return validateLevels(entry.Side, price, stopLoss, takeProfit)
+}
+
+// fillCloseOrder closes the position of the order at the given price, an order larger than the position closes all of it,
+// profile lock must be held
+func (s *TradingService) fillCloseOrder(ctx context.Context, order *model.Order, price float64) error {
+	position, err := s.tradingRps.GetPositionByID(ctx, order.PositionID)
+	if err != nil {
+		return fmt.Errorf("GetPositionByID: %w", err)
+	}
+	if !position.IsOpen {
+		return fmt.Errorf("position %s is already closed", position.ID)
+	}
+	amount := order.Amount
+	if amount > position.Amount {
+		amount = position.Amount
+	}
+	reason := model.CloseReasonStopLoss
+	if order.Type == model.OrderTypeLimit {
+		reason = model.CloseReasonTakeProfit
+	}
+	err = s.closePosition(ctx, position, amount, price, reason)
+	if err != nil {
+		return fmt.Errorf("closePosition: %w", err)
+	}
+	return nil
+}
+
+// updateGroup updates the rest of the group after the order has been filled, rejected or canceled:
+// a filled entry activates its children and an unfilled one cancels them, a filled close order cancels the other ones,
+// profile lock must be held
+func (s *TradingService) updateGroup(ctx context.Context, order *model.Order) {
+	orders, err := s.tradingRps.GetOrdersByGroupID(ctx, order.GroupID)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"order": order}).Errorf("GetOrdersByGroupID: %v", err)
+		return
+	}
+	if order.Action == model.OrderActionClose && order.Status != model.OrderStatusFilled {
+		return
+	}
+	for _, other := range orders {
+		if other.ID == order.ID || (other.Status != model.OrderStatusPending && other.Status != model.OrderStatusInactive) {
+			continue
+		}
+		if order.Action != model.OrderActionClose && order.Status == model.OrderStatusFilled {
+			other.Status = model.OrderStatusPending
+			other.PositionID = order.PositionID
+		} else {
+			other.Status = model.OrderStatusCanceled
+		}
+		other.UpdatedAt = time.Now()
+		if err = s.tradingRps.UpdateOrder(ctx, other); err != nil {
+			logrus.WithFields(logrus.Fields{"order": other}).Errorf("UpdateOrder: %v", err)
+		}
+	}
+}
+
+// cancelCloseOrders cancels pending close orders of the position, profile lock must be held
+func (s *TradingService) cancelCloseOrders(ctx context.Context, positionID uuid.UUID) {
+	orders, err := s.tradingRps.GetPendingOrders(ctx)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"positionID": positionID}).Errorf("GetPendingOrders: %v", err)
+		return
+	}
+	for _, order := range orders {
+		if order.Action != model.OrderActionClose || order.PositionID != positionID {
+			continue
+		}
+		order.Status = model.OrderStatusCanceled
+		order.UpdatedAt = time.Now()
+		if err = s.tradingRps.UpdateOrder(ctx, order); err != nil {
+			logrus.WithFields(logrus.Fields{"order": order}).Errorf("UpdateOrder: %v", err)
+		}
+	}
+}
+
+// newCloseOrder creates an order of the given type closing the position of the target order at the trigger price
+func newCloseOrder(target *model.Order, groupID uuid.UUID, orderType string, triggerPrice float64, status string) *model.Order {
+	return &model.Order{
+		ID:           uuid.New(),
+		ProfileID:    target.ProfileID,
+		Account:      target.Account,
+		ShareName:    target.ShareName,
+		Side:         target.Side,
+		Type:         orderType,
+		Action:       model.OrderActionClose,
+		GroupID:      groupID,
+		Amount:       target.Amount,
+		Leverage:     target.Leverage,
+		TriggerPrice: triggerPrice,
+		Status:       status,
+		PositionID:   target.PositionID,
+		CreatedAt:    target.CreatedAt,
+		UpdatedAt:    target.CreatedAt,
+	}
+}
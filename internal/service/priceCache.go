@@ -0,0 +1,50 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+)
+
+// PriceCache struct represents an in-memory cache of the latest quotes received from the price service,
+// it is written by the price subscription manager only, quotes older than the max age are stale, zero max age makes every quote stale
+type PriceCache struct {
+	mu     sync.RWMutex
+	quotes map[string]model.Quote
+	maxAge time.Duration
+}
+
+// NewPriceCache creates a new PriceCache
+func NewPriceCache(maxAge time.Duration) *PriceCache {
+	return &PriceCache{quotes: make(map[string]model.Quote), maxAge: maxAge}
+}
+
+// Set method stores the price of the share as its latest quote
+func (c *PriceCache) Set(share *model.Shares) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quotes[share.ShareName] = model.Quote{
+		ShareName:  share.ShareName,
+		SharePrice: share.SharePrice,
+		ReceivedAt: time.Now(),
+	}
+}
+
+// Get method returns the latest quote of the share flagged stale if it is older than the max age
+func (c *PriceCache) Get(shareName string) (*model.Quote, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	quote, ok := c.quotes[shareName]
+	if !ok {
+		return nil, false
+	}
+	quote.Stale = time.Since(quote.ReceivedAt) >= c.maxAge
+	return &quote, true
+}
+
+// MaxAge method returns the age after which quotes are stale
+func (c *PriceCache) MaxAge() time.Duration {
+	return c.maxAge
+}
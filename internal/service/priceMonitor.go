@@ -0,0 +1,72 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PriceMonitor struct represents a background consumer of the price stream that drives automatic position closing
+type PriceMonitor struct {
+	tradingSrv    *TradingService
+	subscriptions *PriceSubscriptionManager
+	interval      time.Duration
+}
+
+// NewPriceMonitor creates a new PriceMonitor
+func NewPriceMonitor(tradingSrv *TradingService, subscriptions *PriceSubscriptionManager, interval time.Duration) *PriceMonitor {
+	return &PriceMonitor{
+		tradingSrv:    tradingSrv,
+		subscriptions: subscriptions,
+		interval:      interval,
+	}
+}
+
+// Run method keeps a price subscription to the watched shares until ctx is done and processes every received price and feed event,
+// the watched shares are checked every interval
+func (m *PriceMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	sub := m.subscriptions.Subscribe(nil)
+	defer sub.Close()
+	var watched []string
+	for {
+		shares, err := m.tradingSrv.WatchedShares(ctx)
+		if err != nil {
+			logrus.Errorf("WatchedShares: %v", err)
+		} else if !reflect.DeepEqual(shares, watched) {
+			m.tradingSrv.ForgetFeedGaps(shares)
+			watched = shares
+			sub.SetShares(shares)
+		}
+		if !m.process(ctx, sub, ticker.C) {
+			return
+		}
+	}
+}
+
+// process handles received prices until the next tick and reports whether the monitor should keep running
+func (m *PriceMonitor) process(ctx context.Context, sub *PriceSubscription, next <-chan time.Time) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-next:
+			return true
+		case tick, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			if tick.Feed != nil {
+				logrus.WithFields(logrus.Fields{"shares": tick.Feed.Shares, "error": tick.Feed.Error}).Warnf("price feed %s", tick.Feed.Status)
+				m.tradingSrv.ProcessFeedEvent(tick.Feed)
+				continue
+			}
+			m.tradingSrv.ProcessPrice(ctx, &tick.Shares)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// priceSubscriptionBuffer is the number of prices kept for a consumer that falls behind, older prices are dropped first
+const priceSubscriptionBuffer = 64
+
+// minPriceStreamRetryDelay is the retry delay used instead of a zero one so that a failing stream is not reopened in a busy loop
+const minPriceStreamRetryDelay = 100 * time.Millisecond
+
+// PriceStreamRepository interface represents a repository streaming prices of shares
+type PriceStreamRepository interface {
+	StreamShares(context.Context, []string, func(*model.Shares)) error
+}
+
+// PriceSubscriptionManager struct represents long-lived subscriptions to the price service: consumers subscribed to the same set
+// of shares share one upstream stream, prices of a share carried by several streams are taken from one of them, its owner,
+// and fanned out to consumers of all of them, so every upstream price is numbered and kept for replay once,
+// the latest prices are kept to be replayed to consumers resuming after a disconnect and every price updates the quote cache,
+// a failed upstream stream is reopened with exponential backoff and its consumers are told when the feed is interrupted and restored
+type PriceSubscriptionManager struct {
+	streamRps     PriceStreamRepository
+	priceCache    *PriceCache
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	jitter        *rand.Rand
+	mu            sync.Mutex
+	streams       map[string]*priceStream
+	owners        map[string]*priceStream
+	history       []*model.PriceTick
+	historyLen    int
+	lastID        uint64
+	closed        bool
+}
+
+// priceStream struct represents an upstream stream of a set of shares and its consumers, failures counts reopenings of the stream
+// since the last received price
+type priceStream struct {
+	key         string
+	shares      []string
+	cancel      context.CancelFunc
+	consumers   map[*PriceSubscription]struct{}
+	interrupted bool
+	failures    int
+}
+
+// PriceSubscription struct represents a consumer of prices of a set of shares, prices are received from C
+// which is closed when the subscription is closed
+type PriceSubscription struct {
+	C       <-chan *model.PriceTick
+	ch      chan *model.PriceTick
+	manager *PriceSubscriptionManager
+	key     string
+}
+
+// NewPriceSubscriptionManager creates a new PriceSubscriptionManager, a failed upstream stream is reopened after the retry delay
+// doubled with every failure in a row up to the max retry delay, and the given number of the latest prices is kept for replay
+func NewPriceSubscriptionManager(streamRps PriceStreamRepository, priceCache *PriceCache, retryDelay, maxRetryDelay time.Duration,
+	historyLen int) *PriceSubscriptionManager {
+	if retryDelay <= 0 {
+		retryDelay = minPriceStreamRetryDelay
+	}
+	return &PriceSubscriptionManager{
+		streamRps:     streamRps,
+		priceCache:    priceCache,
+		retryDelay:    retryDelay,
+		maxRetryDelay: maxRetryDelay,
+		jitter:        rand.New(rand.NewSource(time.Now().UnixNano())), // nolint:gosec
+		streams:       make(map[string]*priceStream),
+		owners:        make(map[string]*priceStream),
+		history:       make([]*model.PriceTick, 0, historyLen),
+		historyLen:    historyLen,
+	}
+}
+
+// Subscribe method creates a consumer of prices of the given shares, an empty set of shares receives nothing until it is changed
+func (m *PriceSubscriptionManager) Subscribe(shares []string) *PriceSubscription {
+	ch := make(chan *model.PriceTick, priceSubscriptionBuffer)
+	sub := &PriceSubscription{C: ch, ch: ch, manager: m}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		close(ch)
+		sub.ch = nil
+		return sub
+	}
+	m.attach(sub, shares)
+	return sub
+}
+
+// SetShares method changes the shares the subscription receives prices of without closing it
+func (s *PriceSubscription) SetShares(shares []string) {
+	m := s.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || s.ch == nil || s.key == shareSetKey(shares) {
+		return
+	}
+	m.detach(s)
+	m.attach(s, shares)
+}
+
+// Shares method returns the sorted names of shares the subscription receives prices of
+func (s *PriceSubscription) Shares() []string {
+	m := s.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream, ok := m.streams[s.key]
+	if !ok {
+		return []string{}
+	}
+	return append([]string{}, stream.shares...)
+}
+
+// Close method stops the subscription and closes its channel, the upstream stream is closed with its last consumer
+func (s *PriceSubscription) Close() {
+	m := s.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.ch == nil {
+		return
+	}
+	m.detach(s)
+	close(s.ch)
+	s.ch = nil
+}
+
+// Close method closes all upstream streams and subscriptions
+func (m *PriceSubscriptionManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for key, stream := range m.streams {
+		stream.cancel()
+		for sub := range stream.consumers {
+			close(sub.ch)
+			sub.ch = nil
+		}
+		delete(m.streams, key)
+	}
+	m.owners = make(map[string]*priceStream)
+}
+
+// attach adds the subscription to the stream of the shares and opens the stream if it is the first consumer,
+// a consumer of an interrupted stream is told about the interruption, lock must be held
+func (m *PriceSubscriptionManager) attach(sub *PriceSubscription, shares []string) {
+	sub.key = shareSetKey(shares)
+	if sub.key == "" {
+		return
+	}
+	stream, ok := m.streams[sub.key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		stream = &priceStream{
+			key:       sub.key,
+			shares:    normalizeShares(shares),
+			cancel:    cancel,
+			consumers: make(map[*PriceSubscription]struct{}),
+		}
+		m.streams[sub.key] = stream
+		go m.run(ctx, stream)
+	}
+	stream.consumers[sub] = struct{}{}
+	if stream.interrupted {
+		sub.deliver(feedEvent(model.FeedInterrupted, stream.shares, nil))
+	}
+}
+
+// detach removes the subscription from its stream and closes the stream if it was the last consumer, lock must be held
+func (m *PriceSubscriptionManager) detach(sub *PriceSubscription) {
+	stream, ok := m.streams[sub.key]
+	if !ok {
+		return
+	}
+	delete(stream.consumers, sub)
+	if len(stream.consumers) == 0 {
+		stream.cancel()
+		delete(m.streams, sub.key)
+		for _, share := range stream.shares {
+			if m.owners[share] == stream {
+				delete(m.owners, share)
+			}
+		}
+	}
+}
+
+// run keeps the upstream stream open until ctx is done, reopening it with backoff whenever it ends
+func (m *PriceSubscriptionManager) run(ctx context.Context, stream *priceStream) {
+	for {
+		err := m.streamRps.StreamShares(ctx, stream.shares, func(share *model.Shares) {
+			m.publish(stream, share)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("stream ended")
+		}
+		delay := m.interrupt(stream, err)
+		logrus.WithFields(logrus.Fields{"shares": stream.shares, "retryIn": delay}).Errorf("StreamShares: %v", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// interrupt marks the stream as interrupted, tells its consumers about the first failure in a row
+// and returns the delay before reopening the stream, the jitter source is guarded by the lock
+func (m *PriceSubscriptionManager) interrupt(stream *priceStream, err error) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stream.failures++
+	if !stream.interrupted {
+		stream.interrupted = true
+		event := feedEvent(model.FeedInterrupted, stream.shares, err)
+		for sub := range stream.consumers {
+			sub.deliver(event)
+		}
+	}
+	return backoffDelay(m.jitter, m.retryDelay, m.maxRetryDelay, stream.failures)
+}
+
+// backoffDelay returns the base delay doubled for every failure after the first up to the max delay,
+// randomly shortened by up to a half so that streams failing together are not reopened together
+func backoffDelay(jitter *rand.Rand, base, maxDelay time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(jitter.Int63n(int64(delay-half)+1))
+}
+
+// feedEvent creates a tick telling consumers that the feed of the shares is interrupted by err or restored
+func feedEvent(status string, shares []string, err error) *model.PriceTick {
+	event := &model.FeedEvent{Status: status, Shares: append([]string{}, shares...)}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	return &model.PriceTick{Feed: event, Timestamp: time.Now()}
+}
+
+// publish caches the price as the latest quote, numbers it, keeps it for replay and passes it to every consumer of a stream
+// of its share if the stream owns the share, a stream takes over a share whose owner is closed or interrupted,
+// the first price after an interruption is preceded by the restoration of the feed
+func (m *PriceSubscriptionManager) publish(stream *priceStream, share *model.Shares) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.streams[stream.key] != stream {
+		return
+	}
+	stream.failures = 0
+	if stream.interrupted {
+		stream.interrupted = false
+		event := feedEvent(model.FeedRestored, stream.shares, nil)
+		for sub := range stream.consumers {
+			sub.deliver(event)
+		}
+	}
+	if owner := m.owners[share.ShareName]; owner != stream {
+		if owner != nil && !owner.interrupted {
+			return
+		}
+		m.owners[share.ShareName] = stream
+	}
+	m.priceCache.Set(share)
+	m.lastID++
+	tick := &model.PriceTick{ID: m.lastID, Shares: *share, Timestamp: time.Now()}
+	if m.historyLen > 0 {
+		if len(m.history) == m.historyLen {
+			copy(m.history, m.history[1:])
+			m.history = m.history[:len(m.history)-1]
+		}
+		m.history = append(m.history, tick)
+	}
+	for _, other := range m.streams {
+		i := sort.SearchStrings(other.shares, share.ShareName)
+		if i == len(other.shares) || other.shares[i] != share.ShareName {
+			continue
+		}
+		for sub := range other.consumers {
+			sub.deliver(tick)
+		}
+	}
+}
+
+// replay returns kept prices of the shares received after the price with the given ID, oldest first
+func (m *PriceSubscriptionManager) replay(shares []string, afterID uint64) []*model.PriceTick {
+	set := make(map[string]struct{}, len(shares))
+	for _, share := range shares {
+		set[share] = struct{}{}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start := sort.Search(len(m.history), func(i int) bool {
+		return m.history[i].ID > afterID
+	})
+	ticks := make([]*model.PriceTick, 0)
+	for _, tick := range m.history[start:] {
+		if _, ok := set[tick.ShareName]; ok {
+			ticks = append(ticks, tick)
+		}
+	}
+	return ticks
+}
+
+// deliver passes the price or feed event to the consumer without blocking, the oldest buffered price is dropped
+// if the consumer falls behind while feed events are kept, manager lock must be held
+func (s *PriceSubscription) deliver(tick *model.PriceTick) {
+	select {
+	case s.ch <- tick:
+		return
+	default:
+	}
+	buffered := make([]*model.PriceTick, 0, cap(s.ch)+1)
+	for drained := false; !drained; {
+		select {
+		case queued := <-s.ch:
+			buffered = append(buffered, queued)
+		default:
+			drained = true
+		}
+	}
+	evicted := false
+	for i, queued := range buffered {
+		if queued.Feed == nil {
+			buffered = append(buffered[:i], buffered[i+1:]...)
+			evicted = true
+			break
+		}
+	}
+	if evicted || tick.Feed != nil {
+		buffered = append(buffered, tick)
+	}
+	for _, queued := range buffered {
+		select {
+		case s.ch <- queued:
+		default:
+		}
+	}
+}
+
+// normalizeShares returns sorted unique non-empty share names
+func normalizeShares(shares []string) []string {
+	set := make(map[string]struct{}, len(shares))
+	for _, share := range shares {
+		if share != "" {
+			set[share] = struct{}{}
+		}
+	}
+	normalized := make([]string, 0, len(set))
+	for share := range set {
+		normalized = append(normalized, share)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// shareSetKey returns the key identifying the set of shares regardless of their order and duplicates
+func shareSetKey(shares []string) string {
+	return strings.Join(normalizeShares(shares), ",")
+}
+
+// StreamPrices method passes prices of the shares received from updates and feed events to send until ctx is done or send fails,
+// every value received from updates replaces the shares streamed so far
+func (m *PriceSubscriptionManager) StreamPrices(ctx context.Context, updates <-chan []string, send func(*model.PriceTick) error) error {
+	sub := m.Subscribe(nil)
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case shares, ok := <-updates:
+			if !ok {
+				updates = nil
+				continue
+			}
+			sub.SetShares(shares)
+		case tick, ok := <-sub.C:
+			if !ok {
+				return fmt.Errorf("price subscription closed")
+			}
+			if err := send(tick); err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+		}
+	}
+}
+
+// StreamPricesSince method passes kept prices of the shares received after the price with the given ID to send
+// and then streams new ones and feed events until ctx is done or send fails, zero ID replays nothing,
+// prices no longer kept are skipped
+func (m *PriceSubscriptionManager) StreamPricesSince(ctx context.Context, shares []string, lastID uint64, send func(*model.PriceTick) error) error {
+	sub := m.Subscribe(shares)
+	defer sub.Close()
+	// prices received since subscribing may be both replayed and buffered by the subscription
+	var replayedID uint64
+	if lastID > 0 {
+		for _, tick := range m.replay(shares, lastID) {
+			if err := send(tick); err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+			replayedID = tick.ID
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case tick, ok := <-sub.C:
+			if !ok {
+				return fmt.Errorf("price subscription closed")
+			}
+			if tick.Feed == nil && tick.ID <= replayedID {
+				continue
+			}
+			if err := send(tick); err != nil {
+				return fmt.Errorf("send: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePriceStreamRepository struct {
+	mu       sync.Mutex
+	streams  map[string]int
+	prices   chan *model.Shares
+	failures int
+}
+
+func (r *fakePriceStreamRepository) StreamShares(ctx context.Context, shares []string, handle func(*model.Shares)) error {
+	r.mu.Lock()
+	if r.failures > 0 {
+		r.failures--
+		r.mu.Unlock()
+		return errors.New("connection refused")
+	}
+	r.streams[shareSetKey(shares)]++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.streams[shareSetKey(shares)]--
+		r.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case share := <-r.prices:
+			handle(share)
+		}
+	}
+}
+
+func (r *fakePriceStreamRepository) openStreams(shares ...string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.streams[shareSetKey(shares)]
+}
+
+func TestPriceSubscriptionManagerFansOutOneStreamPerShareSet(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares)}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, time.Millisecond, 10)
+	defer manager.Close()
+
+	first := manager.Subscribe([]string{"Apple", "Tesla"})
+	second := manager.Subscribe([]string{"Tesla", "Apple", "Apple"})
+	require.Eventually(t, func() bool { return streamRps.openStreams("Apple", "Tesla") == 1 }, time.Second, time.Millisecond)
+
+	streamRps.prices <- &model.Shares{ShareName: "Tesla", SharePrice: 200}
+	for _, sub := range []*PriceSubscription{first, second} {
+		select {
+		case share := <-sub.C:
+			require.Equal(t, 200.0, share.SharePrice)
+		case <-time.After(time.Second):
+			t.Fatal("price was not fanned out")
+		}
+	}
+
+	first.SetShares([]string{"Apple"})
+	require.Equal(t, []string{"Apple"}, first.Shares())
+	second.Close()
+	_, ok := <-second.C
+	require.False(t, ok)
+	require.Eventually(t, func() bool {
+		return streamRps.openStreams("Apple", "Tesla") == 0 && streamRps.openStreams("Apple") == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestStreamPricesFollowsShareUpdates(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares)}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, time.Millisecond, 10)
+	defer manager.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan []string)
+	received := make(chan *model.PriceTick, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- manager.StreamPrices(ctx, updates, func(tick *model.PriceTick) error {
+			received <- tick
+			return nil
+		})
+	}()
+	updates <- []string{"Apple"}
+	require.Eventually(t, func() bool { return streamRps.openStreams("Apple") == 1 }, time.Second, time.Millisecond)
+	streamRps.prices <- &model.Shares{ShareName: "Apple", SharePrice: 101}
+	require.Equal(t, 101.0, (<-received).SharePrice)
+
+	updates <- []string{}
+	require.Eventually(t, func() bool { return streamRps.openStreams("Apple") == 0 }, time.Second, time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestSubscribeAfterCloseReturnsClosedSubscription(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares)}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, time.Millisecond, 10)
+	manager.Close()
+
+	sub := manager.Subscribe([]string{"Apple"})
+	_, ok := <-sub.C
+	require.False(t, ok)
+	require.NotPanics(t, func() {
+		sub.SetShares([]string{"Tesla"})
+		sub.Close()
+		sub.Close()
+	})
+	require.Equal(t, 0, streamRps.openStreams("Tesla"))
+}
+
+func TestStreamPricesSinceReplaysMissedPrices(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares)}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, time.Millisecond, 10)
+	defer manager.Close()
+
+	sub := manager.Subscribe([]string{"Apple", "Tesla"})
+	for i, share := range []string{"Apple", "Tesla", "Apple"} {
+		streamRps.prices <- &model.Shares{ShareName: share, SharePrice: float64(100 + i)}
+	}
+	var lastID uint64
+	for i := 0; i < 3; i++ {
+		tick := <-sub.C
+		if i == 0 {
+			lastID = tick.ID
+		}
+	}
+	sub.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	received := make(chan *model.PriceTick, 10)
+	go func() {
+		_ = manager.StreamPricesSince(ctx, []string{"Apple"}, lastID, func(tick *model.PriceTick) error {
+			received <- tick
+			return nil
+		})
+	}()
+	replayed := <-received
+	require.Equal(t, 102.0, replayed.SharePrice, "only missed prices of the requested shares are replayed")
+	require.Eventually(t, func() bool {
+		return streamRps.openStreams("Apple", "Tesla") == 0 && streamRps.openStreams("Apple") == 1
+	}, time.Second, time.Millisecond)
+
+	streamRps.prices <- &model.Shares{ShareName: "Apple", SharePrice: 103}
+	live := <-received
+	require.Equal(t, 103.0, live.SharePrice)
+	require.Greater(t, live.ID, replayed.ID)
+}
+
+func TestPricesOfSharesInSeveralSetsAreKeptOnce(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares)}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, time.Millisecond, 10)
+	defer manager.Close()
+
+	apple := manager.Subscribe([]string{"Apple"})
+	both := manager.Subscribe([]string{"Apple", "Tesla"})
+	manager.mu.Lock()
+	appleStream, bothStream := manager.streams[apple.key], manager.streams[both.key]
+	manager.mu.Unlock()
+
+	// both upstream streams receive the same Apple price
+	for _, stream := range []*priceStream{appleStream, bothStream} {
+		manager.publish(stream, &model.Shares{ShareName: "Apple", SharePrice: 100})
+	}
+	manager.publish(bothStream, &model.Shares{ShareName: "Tesla", SharePrice: 200})
+	first, second := <-apple.C, <-both.C
+	require.Equal(t, first.ID, second.ID)
+	require.Equal(t, 200.0, (<-both.C).SharePrice)
+	require.Len(t, manager.replay([]string{"Apple", "Tesla"}, 0), 2)
+
+	// the other stream takes the share over once its owner is gone
+	apple.Close()
+	manager.publish(bothStream, &model.Shares{ShareName: "Apple", SharePrice: 101})
+	require.Equal(t, 101.0, (<-both.C).SharePrice)
+}
+
+func TestPriceSubscriptionManagerReconnectsAndReportsFeedGaps(t *testing.T) {
+	streamRps := &fakePriceStreamRepository{streams: make(map[string]int), prices: make(chan *model.Shares), failures: 3}
+	manager := NewPriceSubscriptionManager(streamRps, NewPriceCache(time.Second), time.Millisecond, 4*time.Millisecond, 10)
+	defer manager.Close()
+	receive := func(sub *PriceSubscription) *model.PriceTick {
+		select {
+		case tick := <-sub.C:
+			return tick
+		case <-time.After(time.Second):
+			t.Fatal("nothing was received")
+			return nil
+		}
+	}
+
+	sub := manager.Subscribe([]string{"Apple"})
+	tick := receive(sub)
+	require.NotNil(t, tick.Feed)
+	require.Equal(t, model.FeedInterrupted, tick.Feed.Status)
+	require.Equal(t, []string{"Apple"}, tick.Feed.Shares)
+	require.Equal(t, "connection refused", tick.Feed.Error)
+
+	late := manager.Subscribe([]string{"Apple"})
+	require.Equal(t, model.FeedInterrupted, receive(late).Feed.Status, "a new consumer is told about the interruption")
+
+	require.Eventually(t, func() bool { return streamRps.openStreams("Apple") == 1 }, time.Second, time.Millisecond,
+		"the stream is reopened after the failures")
+	streamRps.prices <- &model.Shares{ShareName: "Apple", SharePrice: 150}
+	for _, consumer := range []*PriceSubscription{sub, late} {
+		require.Equal(t, model.FeedRestored, receive(consumer).Feed.Status)
+		tick = receive(consumer)
+		require.Nil(t, tick.Feed)
+		require.Equal(t, 150.0, tick.SharePrice)
+	}
+
+	for failures := 1; failures <= 5; failures++ {
+		delay := backoffDelay(manager.jitter, 10*time.Millisecond, 40*time.Millisecond, failures)
+		ceiling := 10 * time.Millisecond << (failures - 1)
+		if ceiling > 40*time.Millisecond {
+			ceiling = 40 * time.Millisecond
+		}
+		require.GreaterOrEqual(t, delay, ceiling/2)
+		require.LessOrEqual(t, delay, ceiling)
+	}
+}
+
+func TestFeedEventsAreNotDroppedForSlowConsumers(t *testing.T) {
+	ch := make(chan *model.PriceTick, priceSubscriptionBuffer)
+	sub := &PriceSubscription{C: ch, ch: ch}
+	var id uint64
+	price := func() {
+		id++
+		sub.deliver(&model.PriceTick{ID: id, Shares: model.Shares{ShareName: "Apple", SharePrice: float64(id)}})
+	}
+	for i := 0; i < 10; i++ {
+		price()
+	}
+	sub.deliver(feedEvent(model.FeedInterrupted, []string{"Apple"}, nil))
+	for i := 0; i < 100; i++ {
+		price()
+	}
+
+	require.Len(t, sub.C, priceSubscriptionBuffer)
+	first := <-sub.C
+	require.NotNil(t, first.Feed, "the oldest prices are dropped and the feed event is kept")
+	require.Equal(t, model.FeedInterrupted, first.Feed.Status)
+	for want := id - priceSubscriptionBuffer + 2; want <= id; want++ {
+		tick := <-sub.C
+		require.Nil(t, tick.Feed)
+		require.Equal(t, want, tick.ID)
+	}
+}
+
+func TestZeroRetryDelayIsReplaced(t *testing.T) {
+	manager := NewPriceSubscriptionManager(&fakePriceStreamRepository{}, NewPriceCache(time.Second), 0, 0, 10)
+	require.Equal(t, minPriceStreamRetryDelay, manager.retryDelay)
+	require.GreaterOrEqual(t, backoffDelay(manager.jitter, manager.retryDelay, manager.maxRetryDelay, 1), minPriceStreamRetryDelay/2)
+}
@@ -0,0 +1,45 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/sirupsen/logrus"
+)
+
+// maxQuotesPerRequest limits the number of shares whose quotes are returned at once
+const maxQuotesPerRequest = 100
+
+// GetQuote method returns the latest quote of the share, a missing or stale quote is replaced by the live price
+// and the stale one is returned flagged if the live price cannot be received
+func (s *TradingService) GetQuote(ctx context.Context, shareName string) (*model.Quote, error) {
+	if shareName == "" {
+		return nil, fmt.Errorf("share name is empty")
+	}
+	quote, err := s.getQuote(ctx, shareName)
+	if err != nil {
+		if quote == nil {
+			return nil, fmt.Errorf("getQuote: %w", err)
+		}
+		logrus.WithFields(logrus.Fields{"quote": quote}).Errorf("getQuote: %v", err)
+	}
+	return quote, nil
+}
+
+// GetQuotes method returns the latest quotes of the shares in the given order
+func (s *TradingService) GetQuotes(ctx context.Context, shareNames []string) ([]*model.Quote, error) {
+	if len(shareNames) == 0 || len(shareNames) > maxQuotesPerRequest {
+		return nil, fmt.Errorf("1 to %d share names are required, got %d", maxQuotesPerRequest, len(shareNames))
+	}
+	quotes := make([]*model.Quote, 0, len(shareNames))
+	for _, shareName := range shareNames {
+		quote, err := s.GetQuote(ctx, shareName)
+		if err != nil {
+			return nil, fmt.Errorf("GetQuote: %w", err)
+		}
+		quotes = append(quotes, quote)
+	}
+	return quotes, nil
+}
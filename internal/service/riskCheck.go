@@ -0,0 +1,207 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/eugenshima/trading-api/internal/config"
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// RiskTrade struct represents a trade about to be executed together with the state of the account it is executed on,
+// position ID is the position being increased or nil for a new position
+type RiskTrade struct {
+	ProfileID     uuid.UUID
+	Account       string
+	PositionID    uuid.UUID
+	ShareName     string
+	Amount        float64
+	Price         float64
+	Balance       float64
+	OpenPositions []*model.Position
+}
+
+// Notional method returns the value of the trade
+func (t *RiskTrade) Notional() decimal.Decimal {
+	return decimal.NewFromFloat(t.Amount).Mul(decimal.NewFromFloat(t.Price))
+}
+
+// RiskCheck interface represents a pre-trade check, a rejected trade is reported by *model.RiskViolation
+type RiskCheck interface {
+	Check(context.Context, *RiskTrade) error
+}
+
+// RiskCheckFunc type is an adapter to use ordinary functions as risk checks
+type RiskCheckFunc func(context.Context, *RiskTrade) error
+
+// Check method calls f(ctx, trade)
+func (f RiskCheckFunc) Check(ctx context.Context, trade *RiskTrade) error {
+	return f(ctx, trade)
+}
+
+// RiskChain struct represents risk checks run one after another until the first rejection
+type RiskChain struct {
+	checks []RiskCheck
+}
+
+// NewRiskChain creates a new RiskChain of the given checks
+func NewRiskChain(checks ...RiskCheck) *RiskChain {
+	return &RiskChain{checks: checks}
+}
+
+// DefaultRiskChecks function returns the risk checks enabled by the configured limits
+func DefaultRiskChecks(cfg *config.Config) []RiskCheck {
+	checks := make([]RiskCheck, 0)
+	if cfg.RiskMaxNotionalPerShare > 0 {
+		checks = append(checks, maxNotionalPerShareCheck(decimal.NewFromFloat(cfg.RiskMaxNotionalPerShare)))
+	}
+	if cfg.RiskMaxExposure > 0 {
+		checks = append(checks, maxExposureCheck(decimal.NewFromFloat(cfg.RiskMaxExposure)))
+	}
+	if cfg.RiskMaxOpenPositions > 0 {
+		checks = append(checks, maxOpenPositionsCheck(cfg.RiskMaxOpenPositions))
+	}
+	if cfg.RiskMaxOrderBalanceRatio > 0 {
+		checks = append(checks, maxOrderBalanceRatioCheck(decimal.NewFromFloat(cfg.RiskMaxOrderBalanceRatio)))
+	}
+	return checks
+}
+
+// Use method appends checks to the end of the chain
+func (c *RiskChain) Use(checks ...RiskCheck) {
+	c.checks = append(c.checks, checks...)
+}
+
+// Check method runs the checks of the chain and returns the first rejection
+func (c *RiskChain) Check(ctx context.Context, trade *RiskTrade) error {
+	for _, check := range c.checks {
+		if err := check.Check(ctx, trade); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maxNotionalPerShareCheck limits the value of positions on one share, valued at their open prices, after the trade
+func maxNotionalPerShareCheck(limit decimal.Decimal) RiskCheck {
+	return RiskCheckFunc(func(_ context.Context, trade *RiskTrade) error {
+		notional := trade.Notional()
+		for _, position := range trade.OpenPositions {
+			if position.ShareName == trade.ShareName {
+				notional = notional.Add(positionNotional(position))
+			}
+		}
+		if notional.GreaterThan(limit) {
+			return riskViolation(model.RiskRuleMaxNotionalPerShare, limit, notional,
+				fmt.Sprintf("notional %v of share %s would exceed %v", notional, trade.ShareName, limit))
+		}
+		return nil
+	})
+}
+
+// maxExposureCheck limits the value of all positions of the account, valued at their open prices, after the trade
+func maxExposureCheck(limit decimal.Decimal) RiskCheck {
+	return RiskCheckFunc(func(_ context.Context, trade *RiskTrade) error {
+		exposure := trade.Notional()
+		for _, position := range trade.OpenPositions {
+			exposure = exposure.Add(positionNotional(position))
+		}
+		if exposure.GreaterThan(limit) {
+			return riskViolation(model.RiskRuleMaxExposure, limit, exposure,
+				fmt.Sprintf("total exposure %v would exceed %v", exposure, limit))
+		}
+		return nil
+	})
+}
+
+// maxOpenPositionsCheck limits the number of open positions of the account, increasing a position does not open a new one
+func maxOpenPositionsCheck(limit int) RiskCheck {
+	return RiskCheckFunc(func(_ context.Context, trade *RiskTrade) error {
+		if trade.PositionID != uuid.Nil || len(trade.OpenPositions) < limit {
+			return nil
+		}
+		return riskViolation(model.RiskRuleMaxOpenPositions, decimal.NewFromInt(int64(limit)), decimal.NewFromInt(int64(len(trade.OpenPositions)+1)),
+			fmt.Sprintf("%d open positions are allowed", limit))
+	})
+}
+
+// maxOrderBalanceRatioCheck limits the value of the trade relative to the balance of the account
+func maxOrderBalanceRatioCheck(limit decimal.Decimal) RiskCheck {
+	return RiskCheckFunc(func(_ context.Context, trade *RiskTrade) error {
+		maxNotional := decimal.NewFromFloat(trade.Balance).Mul(limit)
+		if notional := trade.Notional(); notional.GreaterThan(maxNotional) {
+			return riskViolation(model.RiskRuleMaxOrderBalanceRatio, maxNotional, notional,
+				fmt.Sprintf("order value %v exceeds %v times the balance %v", notional, limit, trade.Balance))
+		}
+		return nil
+	})
+}
+
+// UseRiskChecks method appends custom checks to the risk chain run before trades
+func (s *TradingService) UseRiskChecks(checks ...RiskCheck) {
+	s.riskChain.Use(checks...)
+}
+
+// checkRisk runs the risk chain for the trade on the account of the profile, profile lock must be held
+func (s *TradingService) checkRisk(ctx context.Context, trade *RiskTrade) error {
+	positions, err := s.tradingRps.GetOpenPositions(ctx)
+	if err != nil {
+		return fmt.Errorf("GetOpenPositions: %w", err)
+	}
+	account := trade.Account
+	if account == "" {
+		account = model.AccountLive
+	}
+	trade.OpenPositions = make([]*model.Position, 0)
+	for _, position := range positions {
+		if position.ProfileID == trade.ProfileID && isAccount(position.Account, account) {
+			trade.OpenPositions = append(trade.OpenPositions, position)
+		}
+	}
+	return s.riskChain.Check(ctx, trade)
+}
+
+// positionNotional returns the value of the position at its open price
+func positionNotional(position *model.Position) decimal.Decimal {
+	return decimal.NewFromFloat(position.Amount).Mul(decimal.NewFromFloat(position.OpenPrice))
+}
+
+// riskViolation creates a violation of the rule
+func riskViolation(rule string, limit, value decimal.Decimal, message string) *model.RiskViolation {
+	return &model.RiskViolation{
+		Rule:    rule,
+		Message: message,
+		Limit:   limit.InexactFloat64(),
+		Value:   value.InexactFloat64(),
+	}
+}
+
+// validateSlippage checks the slippage protection of a market order, the max slippage needs a reference price to compare with
+func validateSlippage(referencePrice, maxSlippagePercent float64) error {
+	if referencePrice < 0 || maxSlippagePercent < 0 {
+		return fmt.Errorf("reference price and max slippage must not be negative")
+	}
+	if maxSlippagePercent > 0 && referencePrice == 0 {
+		return fmt.Errorf("max slippage requires a reference price")
+	}
+	return nil
+}
+
+// checkSlippage rejects execution at the price if it differs from the reference price by more than the max slippage percent,
+// zero max slippage accepts any price
+func checkSlippage(referencePrice, maxSlippagePercent, price float64) error {
+	if maxSlippagePercent == 0 {
+		return nil
+	}
+	reference := decimal.NewFromFloat(referencePrice)
+	slippage := decimal.NewFromFloat(price).Sub(reference).Abs().Div(reference).Mul(decimal.NewFromInt(100))
+	limit := decimal.NewFromFloat(maxSlippagePercent)
+	if slippage.GreaterThan(limit) {
+		return riskViolation(model.RiskRuleMaxSlippage, limit, slippage,
+			fmt.Sprintf("price %v deviates from the reference price %v by %v%%", price, referencePrice, slippage.Round(4)))
+	}
+	return nil
+}
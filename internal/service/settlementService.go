@@ -0,0 +1,142 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// RunSettlementRecovery method applies pending settlements when started and then every interval until the context is done
+func (s *TradingService) RunSettlementRecovery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.RecoverSettlements(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RecoverSettlements method makes one more attempt to apply each pending settlement, oldest first,
+// settlements are left pending by failed balance updates and by restarts between saving and applying them
+func (s *TradingService) RecoverSettlements(ctx context.Context) {
+	settlements, err := s.tradingRps.GetPendingSettlements(ctx)
+	if err != nil {
+		logrus.Errorf("GetPendingSettlements: %v", err)
+		return
+	}
+	for _, settlement := range settlements {
+		if err = s.recoverSettlement(ctx, settlement); err != nil {
+			logrus.WithFields(logrus.Fields{"settlement": settlement}).Warnf("recoverSettlement: %v", err)
+		}
+	}
+}
+
+// recoverSettlement applies the settlement if it is still pending
+func (s *TradingService) recoverSettlement(ctx context.Context, settlement *model.Settlement) error {
+	unlock := s.lockProfile(settlement.ProfileID)
+	defer unlock()
+
+	settlement, err := s.tradingRps.GetSettlementByID(ctx, settlement.ID)
+	if err != nil {
+		return fmt.Errorf("GetSettlementByID: %w", err)
+	}
+	if settlement.Status != model.SettlementStatusPending {
+		return nil
+	}
+	err = s.applySettlement(ctx, settlement)
+	if err != nil {
+		return fmt.Errorf("applySettlement: %w", err)
+	}
+	return nil
+}
+
+// settlePending applies pending settlements of the account of the profile before its balance is changed,
+// the change is refused while one of them is still pending so that every settlement is applied to the balance it was made for,
+// profile lock must be held
+func (s *TradingService) settlePending(ctx context.Context, profileID uuid.UUID, account string) error {
+	settlements, err := s.tradingRps.GetPendingSettlements(ctx)
+	if err != nil {
+		return fmt.Errorf("GetPendingSettlements: %w", err)
+	}
+	for _, settlement := range settlements {
+		if settlement.ProfileID != profileID || settlement.Account != account {
+			continue
+		}
+		err = s.applySettlement(ctx, settlement)
+		if err != nil {
+			return fmt.Errorf("settlement %s is pending: %w", settlement.ID, err)
+		}
+	}
+	return nil
+}
+
+// SettledBalanceService struct represents operations of live balances that apply pending settlements of the profile first
+type SettledBalanceService struct {
+	*BalanceService
+	tradingSrv *TradingService
+}
+
+// NewSettledBalanceService creates a new SettledBalanceService
+func NewSettledBalanceService(balanceSrv *BalanceService, tradingSrv *TradingService) *SettledBalanceService {
+	return &SettledBalanceService{BalanceService: balanceSrv, tradingSrv: tradingSrv}
+}
+
+// DepositMoney method adds money to the balance after the pending settlements of the profile are applied
+func (s *SettledBalanceService) DepositMoney(ctx context.Context, balance *model.Balance) (float64, error) {
+	unlock := s.tradingSrv.lockProfile(balance.ProfileID)
+	defer unlock()
+
+	err := s.tradingSrv.settlePending(ctx, balance.ProfileID, model.AccountLive)
+	if err != nil {
+		return 0, fmt.Errorf("settlePending: %w", err)
+	}
+	return s.BalanceService.DepositMoney(ctx, balance)
+}
+
+// WithdrawMoney method subs money from the balance after the pending settlements of the profile are applied
+func (s *SettledBalanceService) WithdrawMoney(ctx context.Context, balance *model.Balance) (float64, error) {
+	unlock := s.tradingSrv.lockProfile(balance.ProfileID)
+	defer unlock()
+
+	err := s.tradingSrv.settlePending(ctx, balance.ProfileID, model.AccountLive)
+	if err != nil {
+		return 0, fmt.Errorf("settlePending: %w", err)
+	}
+	return s.BalanceService.WithdrawMoney(ctx, balance)
+}
+
+// applySettlement makes one attempt to apply the saved settlement to the balance of its account and records the result:
+// the settlement stays pending if the attempt fails and is marked failed if the balance has been changed by something else,
+// profile lock must be held
+func (s *TradingService) applySettlement(ctx context.Context, settlement *model.Settlement) error {
+	settlement.Attempts++
+	err := s.balanceOf(settlement.Account).ApplySettlement(ctx, settlement)
+	switch {
+	case err == nil:
+		settlement.Status = model.SettlementStatusApplied
+		settlement.Error = ""
+	case errors.Is(err, errBalanceChanged):
+		settlement.Status = model.SettlementStatusFailed
+		settlement.Error = err.Error()
+	default:
+		settlement.Error = err.Error()
+	}
+	settlement.UpdatedAt = time.Now()
+	if updErr := s.tradingRps.UpdateSettlement(ctx, settlement); updErr != nil {
+		logrus.WithFields(logrus.Fields{"settlement": settlement}).Errorf("UpdateSettlement: %v", updErr)
+	}
+	if err != nil {
+		return fmt.Errorf("ApplySettlement: %w", err)
+	}
+	return nil
+}
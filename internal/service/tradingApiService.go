@@ -0,0 +1,1208 @@
+// Package service contains business-logic methods
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/config"
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// limits of a trade history page
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// TradingService struct represents a service for opening and closing positions
+type TradingService struct {
+	tradingRps TradingRepository
+	priceRps   PriceServiceRepository
+	balanceSrv TradingBalanceService
+	paperSrv   TradingBalanceService
+	priceCache *PriceCache
+	cfg        *config.Config
+	riskChain  *RiskChain
+	calendar   *MarketCalendar
+	locks      sync.Map
+	feedGaps   sync.Map
+}
+
+// NewTradingService creates a new TradingService, live accounts are settled by the balance service and paper accounts by the paper one,
+// shares are traded during the sessions of the market calendar
+func NewTradingService(tradingRps TradingRepository, priceRps PriceServiceRepository, balanceSrv, paperSrv TradingBalanceService,
+	priceCache *PriceCache, calendar *MarketCalendar, cfg *config.Config) *TradingService {
+	return &TradingService{
+		tradingRps: tradingRps,
+		priceRps:   priceRps,
+		balanceSrv: balanceSrv,
+		paperSrv:   paperSrv,
+		priceCache: priceCache,
+		cfg:        cfg,
+		riskChain:  NewRiskChain(DefaultRiskChecks(cfg)...),
+		calendar:   calendar,
+	}
+}
+
+// TradingRepository interface represents a repository of trading positions
+type TradingRepository interface {
+	CreatePosition(context.Context, *model.Position) error
+	GetPositionByID(context.Context, uuid.UUID) (*model.Position, error)
+	UpdatePosition(context.Context, *model.Position) error
+	DeletePosition(context.Context, uuid.UUID) error
+	GetOpenPositions(context.Context) ([]*model.Position, error)
+	CreateLiquidation(context.Context, *model.Liquidation) error
+	GetLiquidationsByProfileID(context.Context, uuid.UUID) ([]*model.Liquidation, error)
+	CreateOrder(context.Context, *model.Order) error
+	GetOrderByID(context.Context, uuid.UUID) (*model.Order, error)
+	UpdateOrder(context.Context, *model.Order) error
+	GetPendingOrders(context.Context) ([]*model.Order, error)
+	GetOrdersByGroupID(context.Context, uuid.UUID) ([]*model.Order, error)
+	CreateTradeRecord(context.Context, *model.TradeRecord) error
+	GetTradeHistory(context.Context, *model.TradeHistoryFilter) ([]*model.TradeRecord, error)
+	CreateClientRequest(context.Context, *model.ClientRequest) error
+	UpdateClientRequest(context.Context, *model.ClientRequest) error
+	DeleteClientRequest(context.Context, uuid.UUID) error
+	GetClientRequest(context.Context, uuid.UUID) (*model.ClientRequest, error)
+	UpdatePositionWithSettlement(context.Context, *model.Position, *model.Settlement) error
+	GetSettlementByID(context.Context, uuid.UUID) (*model.Settlement, error)
+	GetPendingSettlements(context.Context) ([]*model.Settlement, error)
+	UpdateSettlement(context.Context, *model.Settlement) error
+	GetSettlementsByProfileID(context.Context, uuid.UUID) ([]*model.Settlement, error)
+}
+
+// PriceServiceRepository interface represents a price-service repository
+type PriceServiceRepository interface {
+	RecvShares(context.Context, []string) ([]*model.Shares, error)
+}
+
+// TradingBalanceService interface represents balance operations used to settle positions
+type TradingBalanceService interface {
+	GetBalance(context.Context, uuid.UUID) (*model.Balance, error)
+	DepositMoney(context.Context, *model.Balance) (float64, error)
+	WithdrawMoney(context.Context, *model.Balance) (float64, error)
+	ApplySettlement(context.Context, *model.Settlement) error
+}
+
+// OpenPosition method opens a long or short position on a share at the current price and reserves its margin from the balance
+func (s *TradingService) OpenPosition(ctx context.Context, profileID uuid.UUID, req *model.OpenPosition) (*model.Position, error) {
+	err := s.validateOpenPosition(req)
+	if err != nil {
+		return nil, fmt.Errorf("validateOpenPosition: %w", err)
+	}
+
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	clientReq, err := s.findClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestOpenPosition)
+	if err != nil {
+		return nil, fmt.Errorf("findClientRequest: %w", err)
+	}
+	if clientReq != nil {
+		return s.replayPosition(ctx, clientReq)
+	}
+	err = s.checkMarketOpen(req.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("checkMarketOpen: %w", err)
+	}
+	err = s.checkFeed(req.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("checkFeed: %w", err)
+	}
+	price, err := s.getSharePrice(ctx, req.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("getSharePrice: %w", err)
+	}
+	err = s.reserveClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestOpenPosition)
+	if err != nil {
+		return nil, fmt.Errorf("reserveClientRequest: %w", err)
+	}
+	position, err := s.openPosition(ctx, profileID, req, price)
+	if err != nil {
+		s.releaseClientRequest(ctx, profileID, req.ClientOrderID)
+		return nil, fmt.Errorf("openPosition: %w", err)
+	}
+	err = s.saveClientRequest(ctx, profileID, req.ClientOrderID, position.ID)
+	if err != nil {
+		return nil, fmt.Errorf("saveClientRequest: %w", err)
+	}
+	return position, nil
+}
+
+// validateOpenPosition checks the request to open a position and fills in default side, leverage and account
+func (s *TradingService) validateOpenPosition(req *model.OpenPosition) error {
+	if req.ShareName == "" {
+		return fmt.Errorf("share name is empty")
+	}
+	if req.Amount <= 0 {
+		return fmt.Errorf("amount must be positive, got %v", req.Amount)
+	}
+	if req.Side == "" {
+		req.Side = model.SideLong
+	}
+	if req.Side != model.SideLong && req.Side != model.SideShort {
+		return fmt.Errorf("unknown side %q", req.Side)
+	}
+	if req.Leverage == 0 {
+		req.Leverage = 1
+	}
+	if err := validateTrailing(req.TrailingDistance, req.TrailingPercent); err != nil {
+		return err
+	}
+	if err := validateSlippage(req.ReferencePrice, req.MaxSlippagePercent); err != nil {
+		return err
+	}
+	account, err := validateAccount(req.Account)
+	if err != nil {
+		return err
+	}
+	req.Account = account
+	if req.Leverage < 1 || req.Leverage > s.cfg.MaxLeverage {
+		return fmt.Errorf("leverage must be between 1 and %v, got %v", s.cfg.MaxLeverage, req.Leverage)
+	}
+	if req.Leverage*s.cfg.MaintenanceMarginRate >= 1 {
+		return fmt.Errorf("initial margin rate of leverage %v must be above maintenance margin rate %v", req.Leverage, s.cfg.MaintenanceMarginRate)
+	}
+	return nil
+}
+
+// openPosition opens a position described by the validated request at the given price, profile lock must be held
+func (s *TradingService) openPosition(ctx context.Context, profileID uuid.UUID, req *model.OpenPosition, price float64) (*model.Position, error) {
+	err := checkSlippage(req.ReferencePrice, req.MaxSlippagePercent, price)
+	if err != nil {
+		return nil, fmt.Errorf("checkSlippage: %w", err)
+	}
+	err = validateLevels(req.Side, price, req.StopLoss, req.TakeProfit)
+	if err != nil {
+		return nil, fmt.Errorf("validateLevels: %w", err)
+	}
+	margin := decimal.NewFromFloat(req.Amount).Mul(decimal.NewFromFloat(price)).Div(decimal.NewFromFloat(req.Leverage)).InexactFloat64()
+	fee := s.tradeFee(req.Amount, price)
+
+	err = s.settlePending(ctx, profileID, req.Account)
+	if err != nil {
+		return nil, fmt.Errorf("settlePending: %w", err)
+	}
+	balance, err := s.balanceOf(req.Account).GetBalance(ctx, profileID)
+	if err != nil {
+		return nil, fmt.Errorf("GetBalance: %w", err)
+	}
+	err = s.checkRisk(ctx, &RiskTrade{
+		ProfileID: profileID,
+		Account:   req.Account,
+		ShareName: req.ShareName,
+		Amount:    req.Amount,
+		Price:     price,
+		Balance:   balance.Balance,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("checkRisk: %w", err)
+	}
+	if decimal.NewFromFloat(balance.Balance).LessThan(decimal.NewFromFloat(margin).Add(fee)) {
+		return nil, fmt.Errorf("insufficient funds: balance %v, margin %v, fee %v", balance.Balance, margin, fee)
+	}
+	position := &model.Position{
+		ID:               uuid.New(),
+		ProfileID:        profileID,
+		Account:          req.Account,
+		ShareName:        req.ShareName,
+		Side:             req.Side,
+		Amount:           req.Amount,
+		Leverage:         req.Leverage,
+		Margin:           margin,
+		OpenPrice:        price,
+		StopLoss:         req.StopLoss,
+		TakeProfit:       req.TakeProfit,
+		TrailingDistance: req.TrailingDistance,
+		TrailingPercent:  req.TrailingPercent,
+		OpenFee:          fee.InexactFloat64(),
+		IsOpen:           true,
+		OpenedAt:         time.Now(),
+		ClientOrderID:    req.ClientOrderID,
+	}
+	if hasTrailingStop(position) {
+		position.TrailingPeak = price
+	}
+	err = s.tradingRps.CreatePosition(ctx, position)
+	if err != nil {
+		return nil, fmt.Errorf("CreatePosition: %w", err)
+	}
+	err = s.settle(ctx, req.Account, profileID, -margin)
+	if err == nil {
+		err = s.chargeFee(ctx, req.Account, profileID, fee)
+		if err != nil {
+			s.refund(ctx, req.Account, profileID, margin)
+		}
+	}
+	if err != nil {
+		if delErr := s.tradingRps.DeletePosition(ctx, position.ID); delErr != nil {
+			logrus.WithFields(logrus.Fields{"position": position}).Errorf("DeletePosition: %v", delErr)
+		}
+		return nil, fmt.Errorf("settle: %w", err)
+	}
+	return position, nil
+}
+
+// ClosePosition method closes the given amount of an open position at the current price, zero amount closes the whole position
+func (s *TradingService) ClosePosition(ctx context.Context, profileID uuid.UUID, req *model.ClosePosition) (*model.Position, error) {
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	clientReq, err := s.findClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestClosePosition)
+	if err != nil {
+		return nil, fmt.Errorf("findClientRequest: %w", err)
+	}
+	if clientReq != nil {
+		return s.replayPosition(ctx, clientReq)
+	}
+	position, err := s.getOpenPosition(ctx, profileID, req.PositionID)
+	if err != nil {
+		return nil, fmt.Errorf("getOpenPosition: %w", err)
+	}
+	err = s.checkMarketOpen(position.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("checkMarketOpen: %w", err)
+	}
+	err = s.checkFeed(position.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("checkFeed: %w", err)
+	}
+	price, err := s.getSharePrice(ctx, position.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("getSharePrice: %w", err)
+	}
+	amount := req.Amount
+	if amount == 0 {
+		amount = position.Amount
+	}
+	err = s.reserveClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestClosePosition)
+	if err != nil {
+		return nil, fmt.Errorf("reserveClientRequest: %w", err)
+	}
+	err = s.closePosition(ctx, position, amount, price, model.CloseReasonManual)
+	if err != nil {
+		s.releaseClientRequest(ctx, profileID, req.ClientOrderID)
+		return nil, fmt.Errorf("closePosition: %w", err)
+	}
+	err = s.saveClientRequest(ctx, profileID, req.ClientOrderID, position.ID)
+	if err != nil {
+		return nil, fmt.Errorf("saveClientRequest: %w", err)
+	}
+	return position, nil
+}
+
+// IncreasePosition method adds the given amount at the current price to an open position and averages its open price
+func (s *TradingService) IncreasePosition(ctx context.Context, profileID uuid.UUID, req *model.IncreasePosition) (*model.Position, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive, got %v", req.Amount)
+	}
+	if err := validateSlippage(req.ReferencePrice, req.MaxSlippagePercent); err != nil {
+		return nil, fmt.Errorf("validateSlippage: %w", err)
+	}
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	clientReq, err := s.findClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestIncreasePosition)
+	if err != nil {
+		return nil, fmt.Errorf("findClientRequest: %w", err)
+	}
+	if clientReq != nil {
+		return s.replayPosition(ctx, clientReq)
+	}
+	position, err := s.getOpenPosition(ctx, profileID, req.PositionID)
+	if err != nil {
+		return nil, fmt.Errorf("getOpenPosition: %w", err)
+	}
+	err = s.checkMarketOpen(position.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("checkMarketOpen: %w", err)
+	}
+	err = s.checkFeed(position.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("checkFeed: %w", err)
+	}
+	price, err := s.getSharePrice(ctx, position.ShareName)
+	if err != nil {
+		return nil, fmt.Errorf("getSharePrice: %w", err)
+	}
+	err = s.reserveClientRequest(ctx, profileID, req.ClientOrderID, model.ClientRequestIncreasePosition)
+	if err != nil {
+		return nil, fmt.Errorf("reserveClientRequest: %w", err)
+	}
+	err = s.increasePosition(ctx, position, req, price)
+	if err != nil {
+		s.releaseClientRequest(ctx, profileID, req.ClientOrderID)
+		return nil, fmt.Errorf("increasePosition: %w", err)
+	}
+	err = s.saveClientRequest(ctx, profileID, req.ClientOrderID, position.ID)
+	if err != nil {
+		return nil, fmt.Errorf("saveClientRequest: %w", err)
+	}
+	return position, nil
+}
+
+// increasePosition adds the requested amount at the given price to the open position, profile lock must be held
+func (s *TradingService) increasePosition(ctx context.Context, position *model.Position, req *model.IncreasePosition, price float64) error {
+	err := checkSlippage(req.ReferencePrice, req.MaxSlippagePercent, price)
+	if err != nil {
+		return fmt.Errorf("checkSlippage: %w", err)
+	}
+	addedMargin := decimal.NewFromFloat(req.Amount).Mul(decimal.NewFromFloat(price)).Div(decimal.NewFromFloat(position.Leverage))
+	fee := s.tradeFee(req.Amount, price)
+	err = s.settlePending(ctx, position.ProfileID, position.Account)
+	if err != nil {
+		return fmt.Errorf("settlePending: %w", err)
+	}
+	balance, err := s.balanceOf(position.Account).GetBalance(ctx, position.ProfileID)
+	if err != nil {
+		return fmt.Errorf("GetBalance: %w", err)
+	}
+	err = s.checkRisk(ctx, &RiskTrade{
+		ProfileID:  position.ProfileID,
+		Account:    position.Account,
+		PositionID: position.ID,
+		ShareName:  position.ShareName,
+		Amount:     req.Amount,
+		Price:      price,
+		Balance:    balance.Balance,
+	})
+	if err != nil {
+		return fmt.Errorf("checkRisk: %w", err)
+	}
+	if decimal.NewFromFloat(balance.Balance).LessThan(addedMargin.Add(fee)) {
+		return fmt.Errorf("insufficient funds: balance %v, margin %v, fee %v", balance.Balance, addedMargin, fee)
+	}
+	prev := *position
+	averagePosition(position, req.Amount, price, addedMargin, fee)
+	err = s.tradingRps.UpdatePosition(ctx, position)
+	if err != nil {
+		return fmt.Errorf("UpdatePosition: %w", err)
+	}
+	err = s.settle(ctx, position.Account, position.ProfileID, addedMargin.Neg().InexactFloat64())
+	if err == nil {
+		err = s.chargeFee(ctx, position.Account, position.ProfileID, fee)
+		if err != nil {
+			s.refund(ctx, position.Account, position.ProfileID, addedMargin.InexactFloat64())
+		}
+	}
+	if err != nil {
+		if updErr := s.tradingRps.UpdatePosition(ctx, &prev); updErr != nil {
+			logrus.WithFields(logrus.Fields{"position": prev}).Errorf("UpdatePosition: %v", updErr)
+		}
+		*position = prev
+		return fmt.Errorf("settle: %w", err)
+	}
+	return nil
+}
+
+// averagePosition adds the amount bought at the given price with its margin and fee to the position and averages its open price
+func averagePosition(position *model.Position, addedAmount, price float64, addedMargin, fee decimal.Decimal) {
+	amount := decimal.NewFromFloat(position.Amount)
+	added := decimal.NewFromFloat(addedAmount)
+	totalAmount := amount.Add(added)
+	position.OpenPrice = amount.Mul(decimal.NewFromFloat(position.OpenPrice)).Add(added.Mul(decimal.NewFromFloat(price))).Div(totalAmount).InexactFloat64()
+	position.Amount = totalAmount.InexactFloat64()
+	position.Margin = decimal.NewFromFloat(position.Margin).Add(addedMargin).InexactFloat64()
+	position.OpenFee = decimal.NewFromFloat(position.OpenFee).Add(fee).InexactFloat64()
+}
+
+// getOpenPosition returns the open position of the given profile
+func (s *TradingService) getOpenPosition(ctx context.Context, profileID, positionID uuid.UUID) (*model.Position, error) {
+	position, err := s.tradingRps.GetPositionByID(ctx, positionID)
+	if err != nil {
+		return nil, fmt.Errorf("GetPositionByID: %w", err)
+	}
+	if position.ProfileID != profileID {
+		return nil, fmt.Errorf("position %s not found", positionID)
+	}
+	if !position.IsOpen {
+		return nil, fmt.Errorf("position %s is already closed", positionID)
+	}
+	return position, nil
+}
+
+// ProcessPrice method fills pending orders on the share whose trigger price is reached, moves trailing stops of open positions
+// after the price, closes the positions whose stop-loss, take-profit or trailing stop level is crossed by the price
+// and liquidates the ones whose equity falls below the maintenance margin, nothing is done while its market is closed,
+// a received price ends an interruption of the feed of the share
+func (s *TradingService) ProcessPrice(ctx context.Context, share *model.Shares) {
+	s.feedGaps.Delete(share.ShareName)
+	if !s.calendar.IsOpen(share.ShareName, time.Now()) {
+		return
+	}
+	s.matchOrders(ctx, share)
+
+	positions, err := s.tradingRps.GetOpenPositions(ctx)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{"share": share}).Errorf("GetOpenPositions: %v", err)
+		return
+	}
+	for _, position := range positions {
+		if position.ShareName != share.ShareName {
+			continue
+		}
+		if isNewTrailingPeak(position, share.SharePrice) {
+			var moved *model.Position
+			moved, err = s.moveTrailingStop(ctx, position.ProfileID, position.ID, share.SharePrice)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{"position": position, "share": share}).Errorf("moveTrailingStop: %v", err)
+				continue
+			}
+			position = moved
+		}
+		reason := triggeredCloseReason(position, share.SharePrice)
+		if reason == "" {
+			if s.isUnderMaintenanceMargin(position, share.SharePrice) {
+				err = s.liquidate(ctx, position.ProfileID, position.ID, share.SharePrice)
+				if err != nil {
+					logrus.WithFields(logrus.Fields{"position": position, "share": share}).Errorf("liquidate: %v", err)
+				}
+			}
+			continue
+		}
+		err = s.closeTriggered(ctx, position.ProfileID, position.ID, share.SharePrice, reason)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"position": position, "share": share, "reason": reason}).Errorf("closeTriggered: %v", err)
+		}
+	}
+}
+
+// ProcessFeedEvent method pauses trading of the shares while their feed is interrupted and resumes it when it is restored
+func (s *TradingService) ProcessFeedEvent(event *model.FeedEvent) {
+	for _, share := range event.Shares {
+		if event.Status == model.FeedInterrupted {
+			s.feedGaps.Store(share, struct{}{})
+		} else {
+			s.feedGaps.Delete(share)
+		}
+	}
+}
+
+// ForgetFeedGaps method drops interruptions of the feed of shares that are not watched any more,
+// no price or feed event of them is received to end the interruption
+func (s *TradingService) ForgetFeedGaps(watched []string) {
+	set := make(map[string]struct{}, len(watched))
+	for _, share := range watched {
+		set[share] = struct{}{}
+	}
+	s.feedGaps.Range(func(share, _ interface{}) bool {
+		if _, ok := set[share.(string)]; !ok {
+			s.feedGaps.Delete(share)
+		}
+		return true
+	})
+}
+
+// checkFeed returns an error if the feed of the share is interrupted
+func (s *TradingService) checkFeed(shareName string) error {
+	if _, ok := s.feedGaps.Load(shareName); ok {
+		return fmt.Errorf("price feed of share %s is interrupted", shareName)
+	}
+	return nil
+}
+
+// WatchedShares method returns sorted names of shares whose prices are needed by open positions and pending orders
+func (s *TradingService) WatchedShares(ctx context.Context) ([]string, error) {
+	positions, err := s.tradingRps.GetOpenPositions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetOpenPositions: %w", err)
+	}
+	orders, err := s.tradingRps.GetPendingOrders(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetPendingOrders: %w", err)
+	}
+	set := make(map[string]struct{})
+	for _, position := range positions {
+		set[position.ShareName] = struct{}{}
+	}
+	for _, order := range orders {
+		set[order.ShareName] = struct{}{}
+	}
+	shares := make([]string, 0, len(set))
+	for share := range set {
+		shares = append(shares, share)
+	}
+	sort.Strings(shares)
+	return shares, nil
+}
+
+// GetPositions method returns open positions of the profile with their unrealized P&L, a P&L without a price is marked unavailable
+func (s *TradingService) GetPositions(ctx context.Context, profileID uuid.UUID, account string) ([]*model.PositionPnL, error) {
+	account, err := validateAccount(account)
+	if err != nil {
+		return nil, fmt.Errorf("validateAccount: %w", err)
+	}
+	positions, err := s.tradingRps.GetOpenPositions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetOpenPositions: %w", err)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].OpenedAt.Before(positions[j].OpenedAt)
+	})
+	positionsPnL := make([]*model.PositionPnL, 0)
+	var price float64
+	for _, position := range positions {
+		if position.ProfileID != profileID || !isAccount(position.Account, account) {
+			continue
+		}
+		price, err = s.getCachedSharePrice(ctx, position.ShareName)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{"position": position}).Errorf("getCachedSharePrice: %v", err)
+			positionsPnL = append(positionsPnL, &model.PositionPnL{
+				Position:         *position,
+				TrailingStop:     trailingStopLevel(position),
+				PriceUnavailable: true,
+			})
+			continue
+		}
+		positionsPnL = append(positionsPnL, valuePosition(position, price))
+	}
+	return positionsPnL, nil
+}
+
+// GetPortfolio method returns a consistent snapshot of the cash, open positions and margin of the profile
+func (s *TradingService) GetPortfolio(ctx context.Context, profileID uuid.UUID, account string) (*model.Portfolio, error) {
+	account, err := validateAccount(account)
+	if err != nil {
+		return nil, fmt.Errorf("validateAccount: %w", err)
+	}
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	balance, err := s.balanceOf(account).GetBalance(ctx, profileID)
+	if err != nil {
+		return nil, fmt.Errorf("GetBalance: %w", err)
+	}
+	positions, err := s.GetPositions(ctx, profileID, account)
+	if err != nil {
+		return nil, fmt.Errorf("GetPositions: %w", err)
+	}
+	marketValue, grossMarketValue, unrealizedPnL, usedMargin := decimal.Zero, decimal.Zero, decimal.Zero, decimal.Zero
+	for _, position := range positions {
+		value := decimal.NewFromFloat(position.Amount).Mul(decimal.NewFromFloat(position.CurrentPrice))
+		grossMarketValue = grossMarketValue.Add(value)
+		if position.Side == model.SideShort {
+			value = value.Neg()
+		}
+		marketValue = marketValue.Add(value)
+		unrealizedPnL = unrealizedPnL.Add(decimal.NewFromFloat(position.UnrealizedPnL))
+		usedMargin = usedMargin.Add(decimal.NewFromFloat(position.Margin))
+	}
+	equity := decimal.NewFromFloat(balance.Balance).Add(usedMargin).Add(unrealizedPnL)
+	return &model.Portfolio{
+		ProfileID:        profileID,
+		Account:          account,
+		Cash:             balance.Balance,
+		MarketValue:      marketValue.InexactFloat64(),
+		GrossMarketValue: grossMarketValue.InexactFloat64(),
+		UnrealizedPnL:    unrealizedPnL.InexactFloat64(),
+		Equity:           equity.InexactFloat64(),
+		UsedMargin:       usedMargin.InexactFloat64(),
+		FreeMargin:       equity.Sub(usedMargin).InexactFloat64(),
+		Positions:        positions,
+		Timestamp:        time.Now(),
+	}, nil
+}
+
+// GetTradeHistory method returns a page of closed positions matching the filter, the cursor is the next cursor of the previous page
+func (s *TradingService) GetTradeHistory(ctx context.Context, filter *model.TradeHistoryFilter, cursor string) (*model.TradeHistory, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultHistoryLimit
+	}
+	if filter.Limit > maxHistoryLimit {
+		filter.Limit = maxHistoryLimit
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && filter.From.After(filter.To) {
+		return nil, fmt.Errorf("from %v is after to %v", filter.From, filter.To)
+	}
+	account, err := validateAccount(filter.Account)
+	if err != nil {
+		return nil, fmt.Errorf("validateAccount: %w", err)
+	}
+	filter.Account = account
+	if cursor != "" {
+		var decoded *model.TradeHistoryCursor
+		decoded, err = decodeHistoryCursor(cursor)
+		if err != nil {
+			return nil, fmt.Errorf("decodeHistoryCursor: %w", err)
+		}
+		filter.Cursor = decoded
+	}
+	limit := filter.Limit
+	filter.Limit++
+	records, err := s.tradingRps.GetTradeHistory(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("GetTradeHistory: %w", err)
+	}
+	history := &model.TradeHistory{Records: records}
+	if len(records) > limit {
+		history.Records = records[:limit]
+		last := history.Records[limit-1]
+		history.NextCursor = encodeHistoryCursor(&model.TradeHistoryCursor{ClosedAt: last.ClosedAt, ID: last.ID})
+	}
+	return history, nil
+}
+
+// GetLiquidations method returns liquidation records of the profile
+func (s *TradingService) GetLiquidations(ctx context.Context, profileID uuid.UUID, account string) ([]*model.Liquidation, error) {
+	account, err := validateAccount(account)
+	if err != nil {
+		return nil, fmt.Errorf("validateAccount: %w", err)
+	}
+	liquidations, err := s.tradingRps.GetLiquidationsByProfileID(ctx, profileID)
+	if err != nil {
+		return nil, fmt.Errorf("GetLiquidationsByProfileID: %w", err)
+	}
+	accountLiquidations := make([]*model.Liquidation, 0)
+	for _, liquidation := range liquidations {
+		if isAccount(liquidation.Account, account) {
+			accountLiquidations = append(accountLiquidations, liquidation)
+		}
+	}
+	return accountLiquidations, nil
+}
+
+// GetSettlements method returns settlements of position closes of the profile, newest first
+func (s *TradingService) GetSettlements(ctx context.Context, profileID uuid.UUID, account string) ([]*model.Settlement, error) {
+	account, err := validateAccount(account)
+	if err != nil {
+		return nil, fmt.Errorf("validateAccount: %w", err)
+	}
+	settlements, err := s.tradingRps.GetSettlementsByProfileID(ctx, profileID)
+	if err != nil {
+		return nil, fmt.Errorf("GetSettlementsByProfileID: %w", err)
+	}
+	accountSettlements := make([]*model.Settlement, 0)
+	for _, settlement := range settlements {
+		if isAccount(settlement.Account, account) {
+			accountSettlements = append(accountSettlements, settlement)
+		}
+	}
+	return accountSettlements, nil
+}
+
+// ResetPaperBalance method restores the initial virtual balance of the paper account of the profile and cancels its pending paper orders,
+// open paper positions have to be closed first
+func (s *TradingService) ResetPaperBalance(ctx context.Context, profileID uuid.UUID) (*model.Balance, error) {
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	positions, err := s.tradingRps.GetOpenPositions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetOpenPositions: %w", err)
+	}
+	for _, position := range positions {
+		if position.ProfileID == profileID && position.Account == model.AccountPaper {
+			return nil, fmt.Errorf("paper position %s is still open", position.ID)
+		}
+	}
+	orders, err := s.tradingRps.GetPendingOrders(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("GetPendingOrders: %w", err)
+	}
+	for _, order := range orders {
+		if order.ProfileID != profileID || order.Account != model.AccountPaper {
+			continue
+		}
+		order.Status = model.OrderStatusCanceled
+		order.UpdatedAt = time.Now()
+		if err = s.tradingRps.UpdateOrder(ctx, order); err != nil {
+			return nil, fmt.Errorf("UpdateOrder: %w", err)
+		}
+		if order.GroupID != uuid.Nil && order.Action != model.OrderActionClose {
+			s.updateGroup(ctx, order)
+		}
+	}
+	err = s.settlePending(ctx, profileID, model.AccountPaper)
+	if err != nil {
+		return nil, fmt.Errorf("settlePending: %w", err)
+	}
+	balance, err := s.paperSrv.GetBalance(ctx, profileID)
+	if err != nil {
+		return nil, fmt.Errorf("GetBalance: %w", err)
+	}
+	diff := decimal.NewFromFloat(s.cfg.PaperInitialBalance).Sub(decimal.NewFromFloat(balance.Balance))
+	err = s.settle(ctx, model.AccountPaper, profileID, diff.InexactFloat64())
+	if err != nil {
+		return nil, fmt.Errorf("settle: %w", err)
+	}
+	return &model.Balance{ProfileID: profileID, Balance: s.cfg.PaperInitialBalance}, nil
+}
+
+// liquidate forcibly closes the position at the given price if its equity is still below the maintenance margin and records the liquidation
+func (s *TradingService) liquidate(ctx context.Context, profileID, positionID uuid.UUID, price float64) error {
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	position, err := s.tradingRps.GetPositionByID(ctx, positionID)
+	if err != nil {
+		return fmt.Errorf("GetPositionByID: %w", err)
+	}
+	if !position.IsOpen || !s.isUnderMaintenanceMargin(position, price) {
+		return nil
+	}
+	err = s.checkMarketOpen(position.ShareName)
+	if err != nil {
+		return fmt.Errorf("checkMarketOpen: %w", err)
+	}
+	equity := positionEquity(position, price)
+	maintenanceMargin := s.maintenanceMargin(position, price)
+	err = s.closePosition(ctx, position, position.Amount, price, model.CloseReasonLiquidation)
+	if err != nil {
+		return fmt.Errorf("closePosition: %w", err)
+	}
+	liquidation := &model.Liquidation{
+		ID:                uuid.New(),
+		PositionID:        position.ID,
+		ProfileID:         position.ProfileID,
+		Account:           position.Account,
+		ShareName:         position.ShareName,
+		Price:             price,
+		Equity:            equity.InexactFloat64(),
+		MaintenanceMargin: maintenanceMargin.InexactFloat64(),
+		Reason:            fmt.Sprintf("equity %v fell below maintenance margin %v", equity, maintenanceMargin),
+		CreatedAt:         position.ClosedAt,
+	}
+	err = s.tradingRps.CreateLiquidation(ctx, liquidation)
+	if err != nil {
+		return fmt.Errorf("CreateLiquidation: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"liquidation": liquidation}).Info("position liquidated")
+	return nil
+}
+
+// isUnderMaintenanceMargin reports whether equity of the position at the given price is below its maintenance margin
+func (s *TradingService) isUnderMaintenanceMargin(position *model.Position, price float64) bool {
+	return positionEquity(position, price).LessThan(s.maintenanceMargin(position, price))
+}
+
+// maintenanceMargin returns the minimal equity required to keep the position open at the given price
+func (s *TradingService) maintenanceMargin(position *model.Position, price float64) decimal.Decimal {
+	return decimal.NewFromFloat(position.Amount).Mul(decimal.NewFromFloat(price)).Mul(decimal.NewFromFloat(s.cfg.MaintenanceMarginRate))
+}
+
+// moveTrailingStop saves the price as the new peak of the trailing stop of the position if it is still open and the price is better
+// than the saved peak, and returns the current state of the position
+func (s *TradingService) moveTrailingStop(ctx context.Context, profileID, positionID uuid.UUID, price float64) (*model.Position, error) {
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	position, err := s.tradingRps.GetPositionByID(ctx, positionID)
+	if err != nil {
+		return nil, fmt.Errorf("GetPositionByID: %w", err)
+	}
+	if !position.IsOpen || !isNewTrailingPeak(position, price) {
+		return position, nil
+	}
+	position.TrailingPeak = price
+	err = s.tradingRps.UpdatePosition(ctx, position)
+	if err != nil {
+		return nil, fmt.Errorf("UpdatePosition: %w", err)
+	}
+	return position, nil
+}
+
+// closeTriggered closes the position at the given price if it is still open
+func (s *TradingService) closeTriggered(ctx context.Context, profileID, positionID uuid.UUID, price float64, reason string) error {
+	unlock := s.lockProfile(profileID)
+	defer unlock()
+
+	position, err := s.tradingRps.GetPositionByID(ctx, positionID)
+	if err != nil {
+		return fmt.Errorf("GetPositionByID: %w", err)
+	}
+	if !position.IsOpen {
+		return nil
+	}
+	err = s.checkMarketOpen(position.ShareName)
+	if err != nil {
+		return fmt.Errorf("checkMarketOpen: %w", err)
+	}
+	err = s.closePosition(ctx, position, position.Amount, price, reason)
+	if err != nil {
+		return fmt.Errorf("closePosition: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{"position": position, "reason": reason}).Info("position closed automatically")
+	return nil
+}
+
+// closePosition closes the given amount of the open position at the given price and settles the margin and P&L
+// of the closed part less the close fee as one ledger entry saved together with the position, the position stays open
+// with the remaining amount, margin and open fee if the amount is less than its size, profile lock must be held
+func (s *TradingService) closePosition(ctx context.Context, position *model.Position, amount, price float64, reason string) error {
+	if amount <= 0 || amount > position.Amount {
+		return fmt.Errorf("amount to close must be between 0 and %v, got %v", position.Amount, amount)
+	}
+	err := s.settlePending(ctx, position.ProfileID, position.Account)
+	if err != nil {
+		return fmt.Errorf("settlePending: %w", err)
+	}
+	balance, err := s.balanceOf(position.Account).GetBalance(ctx, position.ProfileID)
+	if err != nil {
+		return fmt.Errorf("GetBalance: %w", err)
+	}
+	prev := *position
+	closed := *position
+	ratio := decimal.NewFromFloat(amount).Div(decimal.NewFromFloat(position.Amount))
+	closed.Amount = amount
+	closed.Margin = decimal.NewFromFloat(position.Margin).Mul(ratio).InexactFloat64()
+	openFee := decimal.NewFromFloat(position.OpenFee).Mul(ratio)
+	closeFee := s.tradeFee(amount, price)
+	pnl := positionPnL(&closed, price)
+	// the margin reserved for the closed part is returned together with its realized P&L
+	proceeds := positionEquity(&closed, price)
+	closedAt := time.Now()
+
+	position.RealizedPnL = decimal.NewFromFloat(position.RealizedPnL).Add(pnl).InexactFloat64()
+	position.CloseFee = decimal.NewFromFloat(position.CloseFee).Add(closeFee).InexactFloat64()
+	if amount == position.Amount {
+		position.IsOpen = false
+		position.ClosePrice = price
+		position.CloseReason = reason
+		position.ClosedAt = closedAt
+	} else {
+		position.Amount = decimal.NewFromFloat(position.Amount).Sub(decimal.NewFromFloat(amount)).InexactFloat64()
+		position.Margin = decimal.NewFromFloat(position.Margin).Sub(decimal.NewFromFloat(closed.Margin)).InexactFloat64()
+		position.OpenFee = decimal.NewFromFloat(position.OpenFee).Sub(openFee).InexactFloat64()
+	}
+	netAmount := proceeds.Sub(closeFee)
+	settlement := &model.Settlement{
+		ID:            uuid.New(),
+		PositionID:    position.ID,
+		ProfileID:     position.ProfileID,
+		Account:       position.Account,
+		Margin:        closed.Margin,
+		GrossPnL:      pnl.InexactFloat64(),
+		Fees:          closeFee.InexactFloat64(),
+		NetAmount:     netAmount.InexactFloat64(),
+		BalanceBefore: balance.Balance,
+		BalanceAfter:  decimal.NewFromFloat(balance.Balance).Add(netAmount).InexactFloat64(),
+		Status:        model.SettlementStatusPending,
+		CreatedAt:     closedAt,
+		UpdatedAt:     closedAt,
+	}
+	err = s.tradingRps.UpdatePositionWithSettlement(ctx, position, settlement)
+	if err != nil {
+		*position = prev
+		return fmt.Errorf("UpdatePositionWithSettlement: %w", err)
+	}
+	if err = s.applySettlement(ctx, settlement); err != nil {
+		logrus.WithFields(logrus.Fields{"settlement": settlement}).Warnf("applySettlement: %v", err)
+	}
+	record := &model.TradeRecord{
+		ID:          uuid.New(),
+		PositionID:  position.ID,
+		ProfileID:   position.ProfileID,
+		Account:     position.Account,
+		ShareName:   position.ShareName,
+		Side:        position.Side,
+		Amount:      amount,
+		Leverage:    position.Leverage,
+		OpenPrice:   position.OpenPrice,
+		ClosePrice:  price,
+		RealizedPnL: pnl.InexactFloat64(),
+		OpenFee:     openFee.InexactFloat64(),
+		CloseFee:    closeFee.InexactFloat64(),
+		NetPnL:      pnl.Sub(openFee).Sub(closeFee).InexactFloat64(),
+		CloseReason: reason,
+		OpenedAt:    position.OpenedAt,
+		ClosedAt:    closedAt,
+	}
+	if err = s.tradingRps.CreateTradeRecord(ctx, record); err != nil {
+		logrus.WithFields(logrus.Fields{"record": record}).Errorf("CreateTradeRecord: %v", err)
+	}
+	if !position.IsOpen {
+		s.cancelCloseOrders(ctx, position.ID)
+	}
+	return nil
+}
+
+// settle deposits a positive amount to the balance of the given account of the profile or withdraws a negative one
+func (s *TradingService) settle(ctx context.Context, account string, profileID uuid.UUID, amount float64) error {
+	if amount >= 0 {
+		_, err := s.balanceOf(account).DepositMoney(ctx, &model.Balance{ProfileID: profileID, Balance: amount})
+		if err != nil {
+			return fmt.Errorf("DepositMoney: %w", err)
+		}
+		return nil
+	}
+	_, err := s.balanceOf(account).WithdrawMoney(ctx, &model.Balance{ProfileID: profileID, Balance: -amount})
+	if err != nil {
+		return fmt.Errorf("WithdrawMoney: %w", err)
+	}
+	return nil
+}
+
+// balanceOf returns the balance service settling the given account
+func (s *TradingService) balanceOf(account string) TradingBalanceService {
+	if account == model.AccountPaper {
+		return s.paperSrv
+	}
+	return s.balanceSrv
+}
+
+// chargeFee withdraws the trading fee from the balance of the given profile as a separate operation
+func (s *TradingService) chargeFee(ctx context.Context, account string, profileID uuid.UUID, fee decimal.Decimal) error {
+	if !fee.IsPositive() {
+		return nil
+	}
+	return s.settle(ctx, account, profileID, fee.Neg().InexactFloat64())
+}
+
+// refund reverts an already settled amount after a following balance operation failed, failure is only logged
+func (s *TradingService) refund(ctx context.Context, account string, profileID uuid.UUID, amount float64) {
+	if err := s.settle(ctx, account, profileID, amount); err != nil {
+		logrus.WithFields(logrus.Fields{"profileID": profileID, "amount": amount}).Errorf("settle: %v", err)
+	}
+}
+
+// tradeFee calculates the fee of a trade of the given amount at the given price: a flat fee, a percent of the notional
+// and a per-share fee of the highest tier whose minimal amount is reached
+func (s *TradingService) tradeFee(amount, price float64) decimal.Decimal {
+	fee := decimal.NewFromFloat(s.cfg.FeeFlat)
+	notional := decimal.NewFromFloat(amount).Mul(decimal.NewFromFloat(price))
+	fee = fee.Add(notional.Mul(decimal.NewFromFloat(s.cfg.FeePercent)).Div(decimal.NewFromInt(100)))
+	perShare := 0.0
+	for _, tier := range s.cfg.FeeTiers {
+		if amount >= tier.MinAmount {
+			perShare = tier.PerShare
+		}
+	}
+	return fee.Add(decimal.NewFromFloat(amount).Mul(decimal.NewFromFloat(perShare)))
+}
+
+// validateAccount checks the account and returns the live one if it is empty
+func validateAccount(account string) (string, error) {
+	switch account {
+	case "":
+		return model.AccountLive, nil
+	case model.AccountLive, model.AccountPaper:
+		return account, nil
+	}
+	return "", fmt.Errorf("unknown account %q", account)
+}
+
+// isAccount reports whether the record belongs to the account, records saved without an account belong to the live one
+func isAccount(recordAccount, account string) bool {
+	if recordAccount == "" {
+		recordAccount = model.AccountLive
+	}
+	return recordAccount == account
+}
+
+// positionPnL calculates P&L of the given position at the given price: long positions profit when the price rises, short ones when it falls
+func positionPnL(position *model.Position, price float64) decimal.Decimal {
+	diff := decimal.NewFromFloat(price).Sub(decimal.NewFromFloat(position.OpenPrice))
+	if position.Side == model.SideShort {
+		diff = diff.Neg()
+	}
+	return diff.Mul(decimal.NewFromFloat(position.Amount))
+}
+
+// validateLevels checks that stop-loss and take-profit levels are on the proper sides of the price
+func validateLevels(side string, price, stopLoss, takeProfit float64) error {
+	if stopLoss < 0 || takeProfit < 0 {
+		return fmt.Errorf("levels must not be negative")
+	}
+	switch side {
+	case model.SideLong:
+		if stopLoss > 0 && stopLoss >= price {
+			return fmt.Errorf("stop-loss %v of a long position must be below the price %v", stopLoss, price)
+		}
+		if takeProfit > 0 && takeProfit <= price {
+			return fmt.Errorf("take-profit %v of a long position must be above the price %v", takeProfit, price)
+		}
+	case model.SideShort:
+		if stopLoss > 0 && stopLoss <= price {
+			return fmt.Errorf("stop-loss %v of a short position must be above the price %v", stopLoss, price)
+		}
+		if takeProfit > 0 && takeProfit >= price {
+			return fmt.Errorf("take-profit %v of a short position must be below the price %v", takeProfit, price)
+		}
+	}
+	return nil
+}
+
+// triggeredCloseReason returns the reason to close the position at the given price or an empty string
+func triggeredCloseReason(position *model.Position, price float64) string {
+	switch position.Side {
+	case model.SideLong:
+		if position.StopLoss > 0 && price <= position.StopLoss {
+			return model.CloseReasonStopLoss
+		}
+		if position.TakeProfit > 0 && price >= position.TakeProfit {
+			return model.CloseReasonTakeProfit
+		}
+	case model.SideShort:
+		if position.StopLoss > 0 && price >= position.StopLoss {
+			return model.CloseReasonStopLoss
+		}
+		if position.TakeProfit > 0 && price <= position.TakeProfit {
+			return model.CloseReasonTakeProfit
+		}
+	}
+	if stop := trailingStopLevel(position); stop > 0 {
+		if (position.Side == model.SideLong && price <= stop) || (position.Side == model.SideShort && price >= stop) {
+			return model.CloseReasonTrailingStop
+		}
+	}
+	return ""
+}
+
+// validateTrailing checks the trailing stop distance and percent, only one of them can be set
+func validateTrailing(distance, percent float64) error {
+	if distance < 0 || percent < 0 {
+		return fmt.Errorf("trailing distance and percent must not be negative")
+	}
+	if distance > 0 && percent > 0 {
+		return fmt.Errorf("either trailing distance or trailing percent can be set")
+	}
+	if percent >= 100 {
+		return fmt.Errorf("trailing percent must be less than 100, got %v", percent)
+	}
+	return nil
+}
+
+// hasTrailingStop reports whether the position has a trailing stop
+func hasTrailingStop(position *model.Position) bool {
+	return position.TrailingDistance > 0 || position.TrailingPercent > 0
+}
+
+// isNewTrailingPeak reports whether the price is better than the peak of the trailing stop of the position:
+// higher for long positions and lower for short ones
+func isNewTrailingPeak(position *model.Position, price float64) bool {
+	if !hasTrailingStop(position) {
+		return false
+	}
+	if position.Side == model.SideShort {
+		return price < position.TrailingPeak
+	}
+	return price > position.TrailingPeak
+}
+
+// trailingStopLevel returns the price closing the position by its trailing stop or zero if it has none:
+// the peak less the trailing distance for long positions and the peak plus the distance for short ones
+func trailingStopLevel(position *model.Position) float64 {
+	if !hasTrailingStop(position) {
+		return 0
+	}
+	peak := decimal.NewFromFloat(position.TrailingPeak)
+	distance := decimal.NewFromFloat(position.TrailingDistance)
+	if position.TrailingPercent > 0 {
+		distance = peak.Mul(decimal.NewFromFloat(position.TrailingPercent)).Div(decimal.NewFromInt(100))
+	}
+	if position.Side == model.SideShort {
+		return peak.Add(distance).InexactFloat64()
+	}
+	return peak.Sub(distance).InexactFloat64()
+}
+
+// positionEquity returns the reserved margin of the position plus its P&L at the given price
+func positionEquity(position *model.Position, price float64) decimal.Decimal {
+	return decimal.NewFromFloat(position.Margin).Add(positionPnL(position, price))
+}
+
+// encodeHistoryCursor encodes the cursor into an opaque string
+func encodeHistoryCursor(cursor *model.TradeHistoryCursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", cursor.ClosedAt.UnixNano(), cursor.ID)))
+}
+
+// decodeHistoryCursor decodes the cursor encoded by encodeHistoryCursor
+func decodeHistoryCursor(cursor string) (*model.TradeHistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("DecodeString: %w", err)
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ParseInt: %w", err)
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Parse: %w", err)
+	}
+	return &model.TradeHistoryCursor{ClosedAt: time.Unix(0, nanos), ID: id}, nil
+}
+
+// valuePosition calculates unrealized P&L of the position at the given price
+func valuePosition(position *model.Position, price float64) *model.PositionPnL {
+	pnl := positionPnL(position, price)
+	percent := decimal.Zero
+	if position.Margin > 0 {
+		percent = pnl.Div(decimal.NewFromFloat(position.Margin)).Mul(decimal.NewFromInt(100))
+	}
+	return &model.PositionPnL{
+		Position:             *position,
+		CurrentPrice:         price,
+		UnrealizedPnL:        pnl.InexactFloat64(),
+		UnrealizedPnLPercent: percent.Round(2).InexactFloat64(),
+		TrailingStop:         trailingStopLevel(position),
+	}
+}
+
+// getSharePrice returns the price of the given share to trade at: the cached quote if it is fresh and the live price otherwise,
+// trading is refused with *model.StaleQuoteError if only a stale quote is known
+func (s *TradingService) getSharePrice(ctx context.Context, shareName string) (float64, error) {
+	quote, err := s.getQuote(ctx, shareName)
+	if err != nil {
+		if quote != nil {
+			logrus.WithFields(logrus.Fields{"quote": quote}).Errorf("getQuote: %v", err)
+			return 0, &model.StaleQuoteError{ShareName: shareName, ReceivedAt: quote.ReceivedAt, MaxAge: s.priceCache.MaxAge().String()}
+		}
+		return 0, fmt.Errorf("getQuote: %w", err)
+	}
+	return quote.SharePrice, nil
+}
+
+// getQuote returns the latest quote of the given share, a missing or stale quote is replaced by the live price
+// without caching it, the stale quote is returned together with the error if the live price cannot be received
+func (s *TradingService) getQuote(ctx context.Context, shareName string) (*model.Quote, error) {
+	quote, ok := s.priceCache.Get(shareName)
+	if ok && !quote.Stale {
+		return quote, nil
+	}
+	shares, err := s.priceRps.RecvShares(ctx, []string{shareName})
+	if err != nil {
+		return quote, fmt.Errorf("RecvShares: %w", err)
+	}
+	for _, share := range shares {
+		if share.ShareName != shareName {
+			continue
+		}
+		if share.SharePrice <= 0 {
+			return quote, fmt.Errorf("invalid price %v for share %s", share.SharePrice, shareName)
+		}
+		return &model.Quote{ShareName: share.ShareName, SharePrice: share.SharePrice, ReceivedAt: time.Now()}, nil
+	}
+	return quote, fmt.Errorf("no price received for share %s", shareName)
+}
+
+// getCachedSharePrice returns the latest cached price of the given share and falls back to the live price if there is none
+func (s *TradingService) getCachedSharePrice(ctx context.Context, shareName string) (float64, error) {
+	quote, ok := s.priceCache.Get(shareName)
+	if ok {
+		return quote.SharePrice, nil
+	}
+	return s.getSharePrice(ctx, shareName)
+}
+
+// lockProfile serializes trading operations of the given profile and returns the unlock function
+func (s *TradingService) lockProfile(profileID uuid.UUID) func() {
+	mu, _ := s.locks.LoadOrStore(profileID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
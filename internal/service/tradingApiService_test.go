@@ -0,0 +1,851 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eugenshima/trading-api/internal/config"
+	"github.com/eugenshima/trading-api/internal/model"
+	"github.com/eugenshima/trading-api/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeBalanceRepository struct {
+	mu          sync.Mutex
+	balances    map[uuid.UUID]float64
+	failUpdates int
+	lostUpdates int
+}
+
+func (r *fakeBalanceRepository) CreateBalance(_ context.Context, profileID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.balances[profileID] = 0
+	return nil
+}
+
+func (r *fakeBalanceRepository) GetBalance(_ context.Context, profileID uuid.UUID) (*model.Balance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	balance, ok := r.balances[profileID]
+	if !ok {
+		return nil, fmt.Errorf("balance %s not found", profileID)
+	}
+	return &model.Balance{ProfileID: profileID, Balance: balance}, nil
+}
+
+func (r *fakeBalanceRepository) UpdateBalance(_ context.Context, balance *model.Balance) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failUpdates > 0 {
+		r.failUpdates--
+		return fmt.Errorf("balance service unavailable")
+	}
+	r.balances[balance.ProfileID] = balance.Balance
+	if r.lostUpdates > 0 {
+		r.lostUpdates--
+		return fmt.Errorf("deadline exceeded")
+	}
+	return nil
+}
+
+type fakePriceRepository struct {
+	mu     sync.Mutex
+	prices map[string]float64
+}
+
+func (r *fakePriceRepository) RecvShares(_ context.Context, shares []string) ([]*model.Shares, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	price, ok := r.prices[shares[0]]
+	if !ok {
+		return []*model.Shares{}, nil
+	}
+	return []*model.Shares{{ShareName: shares[0], SharePrice: price}}, nil
+}
+
+func (r *fakePriceRepository) setPrice(share string, price float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prices[share] = price
+}
+
+func setupTradingService(balance float64) (*TradingService, *fakeBalanceRepository, *fakePriceRepository, uuid.UUID) {
+	profileID := uuid.New()
+	balanceRps := &fakeBalanceRepository{balances: map[uuid.UUID]float64{profileID: balance}}
+	priceRps := &fakePriceRepository{prices: map[string]float64{"Apple": 100}}
+	cfg, err := config.NewConfig()
+	if err != nil {
+		panic(err)
+	}
+	tradingRps := repository.NewTradingRepository()
+	paperBalanceSrv := NewBalanceService(repository.NewPaperBalanceRepository(tradingRps, cfg.PaperInitialBalance))
+	calendar, err := NewMarketCalendar(nil)
+	if err != nil {
+		panic(err)
+	}
+	srv := NewTradingService(tradingRps, priceRps, NewBalanceService(balanceRps), paperBalanceSrv, NewPriceCache(0), calendar, cfg)
+	return srv, balanceRps, priceRps, profileID
+}
+
+func TestOpenAndClosePosition(t *testing.T) {
+	srv, balanceRps, priceRps, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 3})
+	require.NoError(t, err)
+	require.True(t, position.IsOpen)
+	require.Equal(t, 100.0, position.OpenPrice)
+	require.Equal(t, 700.0, balanceRps.balances[profileID])
+
+	priceRps.setPrice("Apple", 110)
+	closed, err := srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+	require.False(t, closed.IsOpen)
+	require.Equal(t, 110.0, closed.ClosePrice)
+	require.Equal(t, 30.0, closed.RealizedPnL)
+	require.Equal(t, 1030.0, balanceRps.balances[profileID])
+
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.Error(t, err)
+}
+
+func TestShortPositionProfitsWhenPriceFalls(t *testing.T) {
+	srv, balanceRps, priceRps, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Side: model.SideShort, Amount: 2})
+	require.NoError(t, err)
+	require.Equal(t, model.SideShort, position.Side)
+	require.Equal(t, 800.0, balanceRps.balances[profileID])
+
+	priceRps.setPrice("Apple", 80)
+	closed, err := srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+	require.Equal(t, 40.0, closed.RealizedPnL)
+	require.Equal(t, 1040.0, balanceRps.balances[profileID])
+}
+
+func TestOpenPositionInsufficientFunds(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(50)
+
+	_, err := srv.OpenPosition(context.Background(), profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.Error(t, err)
+	require.Equal(t, 50.0, balanceRps.balances[profileID])
+}
+
+func TestClosePositionOfAnotherProfile(t *testing.T) {
+	srv, _, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	_, err = srv.ClosePosition(ctx, uuid.New(), &model.ClosePosition{PositionID: position.ID})
+	require.Error(t, err)
+}
+
+func TestProcessPriceTriggersStopLossAndTakeProfit(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	long, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, StopLoss: 90, TakeProfit: 120})
+	require.NoError(t, err)
+	short, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Side: model.SideShort, Amount: 1, StopLoss: 120, TakeProfit: 90})
+	require.NoError(t, err)
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, StopLoss: 100})
+	require.Error(t, err)
+
+	shares, err := srv.WatchedShares(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []string{"Apple"}, shares)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 95})
+	positions, err := srv.tradingRps.GetOpenPositions(ctx)
+	require.NoError(t, err)
+	require.Len(t, positions, 2)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 85})
+	closedLong, err := srv.tradingRps.GetPositionByID(ctx, long.ID)
+	require.NoError(t, err)
+	require.False(t, closedLong.IsOpen)
+	require.Equal(t, model.CloseReasonStopLoss, closedLong.CloseReason)
+	closedShort, err := srv.tradingRps.GetPositionByID(ctx, short.ID)
+	require.NoError(t, err)
+	require.False(t, closedShort.IsOpen)
+	require.Equal(t, model.CloseReasonTakeProfit, closedShort.CloseReason)
+	require.Equal(t, 1000.0, balanceRps.balances[profileID])
+}
+
+func TestLeveragedPositionIsLiquidated(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 10, Leverage: 5})
+	require.NoError(t, err)
+	require.Equal(t, 200.0, position.Margin)
+	require.Equal(t, 800.0, balanceRps.balances[profileID])
+
+	// equity 200-10*15=50 is above maintenance margin 10*85*0.05=42.5
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 85})
+	liquidations, err := srv.GetLiquidations(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Empty(t, liquidations)
+
+	// equity 200-10*17=30 is below maintenance margin 10*83*0.05=41.5
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 83})
+	liquidations, err = srv.GetLiquidations(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Len(t, liquidations, 1)
+	require.Equal(t, position.ID, liquidations[0].PositionID)
+	require.Equal(t, 83.0, liquidations[0].Price)
+	require.Equal(t, 830.0, balanceRps.balances[profileID])
+
+	closed, err := srv.tradingRps.GetPositionByID(ctx, position.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.CloseReasonLiquidation, closed.CloseReason)
+}
+
+func TestLeverageAtMaintenanceMarginRateIsRejected(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+	srv.cfg.MaxLeverage = 25
+	srv.cfg.MaintenanceMarginRate = 0.05
+
+	_, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 10, Leverage: 20})
+	require.Error(t, err)
+	require.Equal(t, 1000.0, balanceRps.balances[profileID])
+
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 10, Leverage: 19})
+	require.NoError(t, err)
+}
+
+func TestPendingOrdersAreMatchedAndCanceled(t *testing.T) {
+	srv, _, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	limit, err := srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Type: model.OrderTypeLimit, Amount: 1, TriggerPrice: 95})
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusPending, limit.Status)
+	stop, err := srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Type: model.OrderTypeStop, Amount: 1, TriggerPrice: 110})
+	require.NoError(t, err)
+	market, err := srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusFilled, market.Status)
+	require.Equal(t, 100.0, market.FillPrice)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 94})
+	filled, err := srv.tradingRps.GetOrderByID(ctx, limit.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusFilled, filled.Status)
+	require.Equal(t, 94.0, filled.FillPrice)
+
+	pending, err := srv.GetPendingOrders(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	require.Equal(t, stop.ID, pending[0].ID)
+
+	_, err = srv.CancelOrder(ctx, uuid.New(), stop.ID)
+	require.Error(t, err)
+	canceled, err := srv.CancelOrder(ctx, profileID, stop.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusCanceled, canceled.Status)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 120})
+	pending, err = srv.GetPendingOrders(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+func TestGetPositionsUsesCachedQuotes(t *testing.T) {
+	srv, _, priceRps, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	_, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 4, Leverage: 2})
+	require.NoError(t, err)
+
+	priceRps.setPrice("Apple", 200)
+	srv.priceCache.Set(&model.Shares{ShareName: "Apple", SharePrice: 110.1})
+	positions, err := srv.GetPositions(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	require.Equal(t, 110.1, positions[0].CurrentPrice)
+	require.Equal(t, 40.4, positions[0].UnrealizedPnL)
+	require.Equal(t, 20.2, positions[0].UnrealizedPnLPercent)
+
+	unpriced := &model.Position{ID: uuid.New(), ProfileID: profileID, Account: model.AccountLive, ShareName: "Tesla", Amount: 1,
+		Margin: 50, OpenPrice: 50, IsOpen: true, OpenedAt: time.Now()}
+	require.NoError(t, srv.tradingRps.CreatePosition(ctx, unpriced))
+	positions, err = srv.GetPositions(ctx, profileID, "")
+	require.NoError(t, err, "a share without a price does not fail the list")
+	require.Len(t, positions, 2)
+	require.False(t, positions[0].PriceUnavailable)
+	require.True(t, positions[1].PriceUnavailable)
+	require.Zero(t, positions[1].UnrealizedPnL)
+}
+
+func TestGetPortfolio(t *testing.T) {
+	srv, _, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	_, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 4, Leverage: 2})
+	require.NoError(t, err)
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Side: model.SideShort, Amount: 1})
+	require.NoError(t, err)
+
+	srv.priceCache.Set(&model.Shares{ShareName: "Apple", SharePrice: 110})
+	portfolio, err := srv.GetPortfolio(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Len(t, portfolio.Positions, 2)
+	require.Equal(t, 700.0, portfolio.Cash)
+	require.Equal(t, 330.0, portfolio.MarketValue)
+	require.Equal(t, 550.0, portfolio.GrossMarketValue)
+	require.Equal(t, 30.0, portfolio.UnrealizedPnL)
+	require.Equal(t, 300.0, portfolio.UsedMargin)
+	require.Equal(t, 1030.0, portfolio.Equity)
+	require.Equal(t, 730.0, portfolio.FreeMargin)
+}
+
+func TestGetTradeHistoryPagination(t *testing.T) {
+	srv, _, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+		require.NoError(t, err)
+		_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+		require.NoError(t, err)
+	}
+
+	page, err := srv.GetTradeHistory(ctx, &model.TradeHistoryFilter{ProfileID: profileID, Limit: 2}, "")
+	require.NoError(t, err)
+	require.Len(t, page.Records, 2)
+	require.NotEmpty(t, page.NextCursor)
+	require.Equal(t, model.CloseReasonManual, page.Records[0].CloseReason)
+
+	next, err := srv.GetTradeHistory(ctx, &model.TradeHistoryFilter{ProfileID: profileID, Limit: 2}, page.NextCursor)
+	require.NoError(t, err)
+	require.Len(t, next.Records, 1)
+	require.Empty(t, next.NextCursor)
+	require.NotEqual(t, page.Records[1].ID, next.Records[0].ID)
+
+	other, err := srv.GetTradeHistory(ctx, &model.TradeHistoryFilter{ProfileID: profileID, ShareName: "Tesla"}, "")
+	require.NoError(t, err)
+	require.Empty(t, other.Records)
+}
+
+func TestPartialCloseAndScaleIn(t *testing.T) {
+	srv, balanceRps, priceRps, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 2})
+	require.NoError(t, err)
+
+	priceRps.setPrice("Apple", 130)
+	increased, err := srv.IncreasePosition(ctx, profileID, &model.IncreasePosition{PositionID: position.ID, Amount: 1})
+	require.NoError(t, err)
+	require.Equal(t, 3.0, increased.Amount)
+	require.Equal(t, 110.0, increased.OpenPrice)
+	require.Equal(t, 330.0, increased.Margin)
+	require.Equal(t, 670.0, balanceRps.balances[profileID])
+
+	priceRps.setPrice("Apple", 120)
+	partial, err := srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID, Amount: 1})
+	require.NoError(t, err)
+	require.True(t, partial.IsOpen)
+	require.Equal(t, 2.0, partial.Amount)
+	require.Equal(t, 220.0, partial.Margin)
+	require.Equal(t, 10.0, partial.RealizedPnL)
+	require.Equal(t, 790.0, balanceRps.balances[profileID])
+
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID, Amount: 3})
+	require.Error(t, err)
+
+	closed, err := srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+	require.False(t, closed.IsOpen)
+	require.Equal(t, 30.0, closed.RealizedPnL)
+	require.Equal(t, 1030.0, balanceRps.balances[profileID])
+
+	history, err := srv.GetTradeHistory(ctx, &model.TradeHistoryFilter{ProfileID: profileID}, "")
+	require.NoError(t, err)
+	require.Len(t, history.Records, 2)
+}
+
+func TestFeesAreChargedOnOpenAndClose(t *testing.T) {
+	srv, balanceRps, priceRps, profileID := setupTradingService(10000)
+	srv.cfg.FeeFlat = 1
+	srv.cfg.FeePercent = 0.1
+	srv.cfg.FeeTiers = []config.FeeTier{{MinAmount: 0, PerShare: 0.01}, {MinAmount: 100, PerShare: 0.005}}
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 10})
+	require.NoError(t, err)
+	require.Equal(t, 2.1, position.OpenFee)
+	require.Equal(t, 8997.9, balanceRps.balances[profileID])
+
+	priceRps.setPrice("Apple", 110)
+	position, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID, Amount: 5})
+	require.NoError(t, err)
+	require.Equal(t, 1.05, position.OpenFee)
+	require.Equal(t, 1.6, position.CloseFee)
+	require.Equal(t, 9546.3, balanceRps.balances[profileID])
+
+	history, err := srv.GetTradeHistory(ctx, &model.TradeHistoryFilter{ProfileID: profileID}, "")
+	require.NoError(t, err)
+	require.Len(t, history.Records, 1)
+	require.Equal(t, 1.05, history.Records[0].OpenFee)
+	require.Equal(t, 1.6, history.Records[0].CloseFee)
+	require.Equal(t, 47.35, history.Records[0].NetPnL)
+
+	priceRps.setPrice("Apple", 10)
+	position, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 100})
+	require.NoError(t, err)
+	require.Equal(t, 2.5, position.OpenFee)
+	require.Equal(t, 8543.8, balanceRps.balances[profileID])
+}
+
+func TestClientOrderIDMakesRequestsIdempotent(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ClientOrderID: "bot-1", ShareName: "Apple", Amount: 2})
+	require.NoError(t, err)
+	retried, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ClientOrderID: "bot-1", ShareName: "Apple", Amount: 2})
+	require.NoError(t, err)
+	require.Equal(t, position.ID, retried.ID)
+	require.Equal(t, 800.0, balanceRps.balances[profileID])
+
+	otherProfileID := uuid.New()
+	balanceRps.balances[otherProfileID] = 1000
+	other, err := srv.OpenPosition(ctx, otherProfileID, &model.OpenPosition{ClientOrderID: "bot-1", ShareName: "Apple", Amount: 2})
+	require.NoError(t, err)
+	require.NotEqual(t, position.ID, other.ID)
+
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{ClientOrderID: "bot-1", PositionID: position.ID})
+	require.Error(t, err)
+
+	order, err := srv.CreateOrder(ctx, profileID, &model.CreateOrder{ClientOrderID: "bot-2", ShareName: "Apple", Type: model.OrderTypeLimit, Amount: 1, TriggerPrice: 90})
+	require.NoError(t, err)
+	retriedOrder, err := srv.CreateOrder(ctx, profileID, &model.CreateOrder{ClientOrderID: "bot-2", ShareName: "Apple", Type: model.OrderTypeLimit, Amount: 1, TriggerPrice: 90})
+	require.NoError(t, err)
+	require.Equal(t, order.ID, retriedOrder.ID)
+	orders, err := srv.GetPendingOrders(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ClientOrderID: "bot-3", ShareName: "Apple", Amount: 100})
+	require.Error(t, err)
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ClientOrderID: "bot-3", ShareName: "Apple", Amount: 1})
+	require.NoError(t, err, "a failed request can be retried with the same client order ID")
+
+	require.NoError(t, srv.reserveClientRequest(ctx, profileID, "bot-4", model.ClientRequestOpenPosition))
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ClientOrderID: "bot-4", ShareName: "Apple", Amount: 1})
+	require.Error(t, err, "a request whose result was not saved is not executed again")
+	require.Equal(t, 700.0, balanceRps.balances[profileID])
+
+	tradingRps := srv.tradingRps
+	srv.tradingRps = failingOrderRepository{TradingRepository: tradingRps}
+	_, err = srv.CreateOrder(ctx, profileID, &model.CreateOrder{ClientOrderID: "bot-5", ShareName: "Apple", Amount: 1})
+	require.Error(t, err)
+	require.Equal(t, 600.0, balanceRps.balances[profileID])
+	srv.tradingRps = tradingRps
+	_, err = srv.CreateOrder(ctx, profileID, &model.CreateOrder{ClientOrderID: "bot-5", ShareName: "Apple", Amount: 1})
+	require.Error(t, err, "a filled order that was not saved is not filled again")
+	require.Equal(t, 600.0, balanceRps.balances[profileID])
+}
+
+type failingOrderRepository struct {
+	TradingRepository
+}
+
+func (failingOrderRepository) CreateOrder(context.Context, *model.Order) error {
+	return fmt.Errorf("store unavailable")
+}
+
+func TestPaperAccountUsesVirtualBalance(t *testing.T) {
+	srv, balanceRps, priceRps, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{Account: model.AccountPaper, ShareName: "Apple", Amount: 50})
+	require.NoError(t, err)
+	require.Equal(t, model.AccountPaper, position.Account)
+	require.Equal(t, 1000.0, balanceRps.balances[profileID])
+
+	live, err := srv.GetPositions(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Empty(t, live)
+	portfolio, err := srv.GetPortfolio(ctx, profileID, model.AccountPaper)
+	require.NoError(t, err)
+	require.Len(t, portfolio.Positions, 1)
+	require.Equal(t, 95000.0, portfolio.Cash)
+
+	_, err = srv.ResetPaperBalance(ctx, profileID)
+	require.Error(t, err)
+
+	priceRps.setPrice("Apple", 120)
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+	require.Equal(t, 1000.0, balanceRps.balances[profileID])
+	portfolio, err = srv.GetPortfolio(ctx, profileID, model.AccountPaper)
+	require.NoError(t, err)
+	require.Equal(t, 101000.0, portfolio.Cash)
+
+	history, err := srv.GetTradeHistory(ctx, &model.TradeHistoryFilter{ProfileID: profileID, Account: model.AccountPaper}, "")
+	require.NoError(t, err)
+	require.Len(t, history.Records, 1)
+	history, err = srv.GetTradeHistory(ctx, &model.TradeHistoryFilter{ProfileID: profileID}, "")
+	require.NoError(t, err)
+	require.Empty(t, history.Records)
+
+	bracket, err := srv.CreateBracketOrder(ctx, profileID, &model.CreateBracketOrder{
+		Entry:      model.CreateOrder{Account: model.AccountPaper, ShareName: "Apple", Type: model.OrderTypeLimit, Amount: 1, TriggerPrice: 95},
+		TakeProfit: 110,
+		StopLoss:   90,
+	})
+	require.NoError(t, err)
+	balance, err := srv.ResetPaperBalance(ctx, profileID)
+	require.NoError(t, err)
+	require.Equal(t, 100000.0, balance.Balance)
+	for _, order := range []*model.Order{bracket.Entry, bracket.TakeProfit, bracket.StopLoss} {
+		order, err = srv.tradingRps.GetOrderByID(ctx, order.ID)
+		require.NoError(t, err)
+		require.Equal(t, model.OrderStatusCanceled, order.Status, "reset cancels the whole bracket")
+	}
+	portfolio, err = srv.GetPortfolio(ctx, profileID, model.AccountPaper)
+	require.NoError(t, err)
+	require.Equal(t, 100000.0, portfolio.Cash)
+}
+
+func TestTrailingStopFollowsBestPrice(t *testing.T) {
+	srv, _, priceRps, profileID := setupTradingService(1000)
+	ctx := context.Background()
+	priceRps.setPrice("Tesla", 100)
+
+	long, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, TrailingDistance: 5})
+	require.NoError(t, err)
+	require.Equal(t, 100.0, long.TrailingPeak)
+	short, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Tesla", Side: model.SideShort, Amount: 1, TrailingPercent: 10})
+	require.NoError(t, err)
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, TrailingDistance: 5, TrailingPercent: 10})
+	require.Error(t, err)
+
+	for _, price := range []float64{110, 120, 116} {
+		srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: price})
+	}
+	position, err := srv.tradingRps.GetPositionByID(ctx, long.ID)
+	require.NoError(t, err)
+	require.True(t, position.IsOpen)
+	require.Equal(t, 120.0, position.TrailingPeak)
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 115})
+	position, err = srv.tradingRps.GetPositionByID(ctx, long.ID)
+	require.NoError(t, err)
+	require.False(t, position.IsOpen)
+	require.Equal(t, model.CloseReasonTrailingStop, position.CloseReason)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Tesla", SharePrice: 80})
+	positions, err := srv.GetPositions(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Len(t, positions, 1)
+	require.Equal(t, 88.0, positions[0].TrailingStop)
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Tesla", SharePrice: 88})
+	position, err = srv.tradingRps.GetPositionByID(ctx, short.ID)
+	require.NoError(t, err)
+	require.False(t, position.IsOpen)
+	require.Equal(t, model.CloseReasonTrailingStop, position.CloseReason)
+}
+
+func TestBracketAndOCOOrders(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	bracket, err := srv.CreateBracketOrder(ctx, profileID, &model.CreateBracketOrder{
+		Entry:      model.CreateOrder{ShareName: "Apple", Type: model.OrderTypeLimit, Amount: 1, TriggerPrice: 95},
+		TakeProfit: 110,
+		StopLoss:   90,
+	})
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusInactive, bracket.TakeProfit.Status)
+	_, err = srv.CreateBracketOrder(ctx, profileID, &model.CreateBracketOrder{
+		Entry:      model.CreateOrder{ShareName: "Apple", Type: model.OrderTypeLimit, Amount: 1, TriggerPrice: 95},
+		TakeProfit: 90,
+		StopLoss:   110,
+	})
+	require.Error(t, err)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 94})
+	entry, err := srv.tradingRps.GetOrderByID(ctx, bracket.Entry.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusFilled, entry.Status)
+	takeProfit, err := srv.tradingRps.GetOrderByID(ctx, bracket.TakeProfit.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusPending, takeProfit.Status)
+	require.Equal(t, entry.PositionID, takeProfit.PositionID)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 111})
+	takeProfit, err = srv.tradingRps.GetOrderByID(ctx, bracket.TakeProfit.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusFilled, takeProfit.Status)
+	stopLoss, err := srv.tradingRps.GetOrderByID(ctx, bracket.StopLoss.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusCanceled, stopLoss.Status)
+	position, err := srv.tradingRps.GetPositionByID(ctx, entry.PositionID)
+	require.NoError(t, err)
+	require.False(t, position.IsOpen)
+	require.Equal(t, model.CloseReasonTakeProfit, position.CloseReason)
+	require.Equal(t, 1017.0, balanceRps.balances[profileID])
+
+	position, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 2})
+	require.NoError(t, err)
+	oco, err := srv.CreateOCOOrder(ctx, profileID, &model.CreateOCOOrder{PositionID: position.ID, Amount: 1, TakeProfit: 105, StopLoss: 95})
+	require.NoError(t, err)
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 94})
+	stopLoss, err = srv.tradingRps.GetOrderByID(ctx, oco.StopLoss.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusFilled, stopLoss.Status)
+	takeProfit, err = srv.tradingRps.GetOrderByID(ctx, oco.TakeProfit.ID)
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusCanceled, takeProfit.Status)
+	position, err = srv.tradingRps.GetPositionByID(ctx, position.ID)
+	require.NoError(t, err)
+	require.True(t, position.IsOpen)
+	require.Equal(t, 1.0, position.Amount)
+}
+
+func TestRiskChecksRejectTrades(t *testing.T) {
+	srv, _, priceRps, profileID := setupTradingService(1000)
+	priceRps.setPrice("Tesla", 100)
+	srv.cfg.RiskMaxNotionalPerShare = 500
+	srv.cfg.RiskMaxOpenPositions = 2
+	srv.cfg.RiskMaxOrderBalanceRatio = 0.5
+	srv.riskChain = NewRiskChain(DefaultRiskChecks(srv.cfg)...)
+	ctx := context.Background()
+
+	var violation *model.RiskViolation
+	_, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 6})
+	require.True(t, errors.As(err, &violation))
+	require.Equal(t, model.RiskRuleMaxNotionalPerShare, violation.Rule)
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 3})
+	require.NoError(t, err)
+	_, err = srv.IncreasePosition(ctx, profileID, &model.IncreasePosition{PositionID: position.ID, Amount: 3})
+	require.True(t, errors.As(err, &violation))
+	require.Equal(t, model.RiskRuleMaxNotionalPerShare, violation.Rule)
+	require.Equal(t, 600.0, violation.Value)
+
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Tesla", Amount: 4})
+	require.True(t, errors.As(err, &violation))
+	require.Equal(t, model.RiskRuleMaxOrderBalanceRatio, violation.Rule)
+
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Tesla", Amount: 1})
+	require.True(t, errors.As(err, &violation))
+	require.Equal(t, model.RiskRuleMaxOpenPositions, violation.Rule)
+
+	srv.UseRiskChecks(RiskCheckFunc(func(context.Context, *RiskTrade) error {
+		return &model.RiskViolation{Rule: "custom"}
+	}))
+	_, err = srv.IncreasePosition(ctx, profileID, &model.IncreasePosition{PositionID: position.ID, Amount: 1})
+	require.True(t, errors.As(err, &violation))
+	require.Equal(t, "custom", violation.Rule)
+}
+
+func TestSlippageProtectionRejectsMarketOrders(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	_, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, MaxSlippagePercent: 1})
+	require.Error(t, err, "max slippage requires a reference price")
+
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, ReferencePrice: 95, MaxSlippagePercent: 1})
+	var violation *model.RiskViolation
+	require.True(t, errors.As(err, &violation))
+	require.Equal(t, model.RiskRuleMaxSlippage, violation.Rule)
+	require.Equal(t, 1000.0, balanceRps.balances[profileID])
+
+	order, err := srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Amount: 1, ReferencePrice: 101, MaxSlippagePercent: 0.5})
+	require.Error(t, err)
+	require.Nil(t, order)
+
+	order, err = srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Amount: 1, ReferencePrice: 99.5, MaxSlippagePercent: 1})
+	require.NoError(t, err)
+	require.Equal(t, model.OrderStatusFilled, order.Status)
+
+	_, err = srv.IncreasePosition(ctx, profileID, &model.IncreasePosition{PositionID: order.PositionID, Amount: 1, ReferencePrice: 90, MaxSlippagePercent: 5})
+	require.True(t, errors.As(err, &violation))
+
+	_, err = srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Type: model.OrderTypeLimit, TriggerPrice: 90, Amount: 1,
+		ReferencePrice: 100, MaxSlippagePercent: 1})
+	require.Error(t, err, "max slippage applies to market orders only")
+}
+
+func TestClosesAreSettledThroughTheLedger(t *testing.T) {
+	srv, balanceRps, priceRps, profileID := setupTradingService(1000)
+	srv.cfg.FeeFlat = 1
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 2})
+	require.NoError(t, err)
+	require.Equal(t, 799.0, balanceRps.balances[profileID])
+
+	priceRps.setPrice("Apple", 110)
+	balanceRps.failUpdates = 1
+	position, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID, Amount: 1})
+	require.NoError(t, err, "failed balance update leaves the settlement pending")
+	require.Equal(t, 1.0, position.Amount)
+	require.Equal(t, 799.0, balanceRps.balances[profileID])
+
+	settlements, err := srv.tradingRps.GetPendingSettlements(ctx)
+	require.NoError(t, err)
+	require.Len(t, settlements, 1)
+	require.Equal(t, 1, settlements[0].Attempts)
+	require.Equal(t, 10.0, settlements[0].GrossPnL)
+	require.Equal(t, 1.0, settlements[0].Fees)
+	require.Equal(t, 109.0, settlements[0].NetAmount)
+	require.Equal(t, 799.0, settlements[0].BalanceBefore)
+	require.Equal(t, 908.0, settlements[0].BalanceAfter)
+
+	srv.RecoverSettlements(ctx)
+	srv.RecoverSettlements(ctx)
+	require.Equal(t, 908.0, balanceRps.balances[profileID], "applied settlement is not applied again")
+	settlement, err := srv.tradingRps.GetSettlementByID(ctx, settlements[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, model.SettlementStatusApplied, settlement.Status)
+	require.Equal(t, 2, settlement.Attempts)
+
+	balanceRps.lostUpdates = 1
+	position, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+	require.False(t, position.IsOpen)
+	require.Equal(t, 1017.0, balanceRps.balances[profileID])
+	srv.RecoverSettlements(ctx)
+	require.Equal(t, 1017.0, balanceRps.balances[profileID], "update whose response was lost is not applied twice")
+
+	position, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	require.Equal(t, 906.0, balanceRps.balances[profileID])
+	balanceRps.failUpdates = 1
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+	balanceRps.balances[profileID] += 50
+	srv.RecoverSettlements(ctx)
+	require.Equal(t, 956.0, balanceRps.balances[profileID], "balance changed by something else is not overwritten")
+
+	settlements, err = srv.GetSettlements(ctx, profileID, "")
+	require.NoError(t, err)
+	require.Len(t, settlements, 3)
+	statuses := make(map[string]int)
+	for _, settlement := range settlements {
+		statuses[settlement.Status]++
+	}
+	require.Equal(t, map[string]int{model.SettlementStatusApplied: 2, model.SettlementStatusFailed: 1}, statuses)
+	pending, err := srv.tradingRps.GetPendingSettlements(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+}
+
+type failingPriceRepository struct{}
+
+func (failingPriceRepository) RecvShares(_ context.Context, _ []string) ([]*model.Shares, error) {
+	return nil, fmt.Errorf("price service unavailable")
+}
+
+func TestStaleQuotesAreFlaggedAndNotTraded(t *testing.T) {
+	srv, _, _, profileID := setupTradingService(1000)
+	srv.priceCache = NewPriceCache(time.Hour)
+	ctx := context.Background()
+
+	srv.priceCache.Set(&model.Shares{ShareName: "Apple", SharePrice: 105})
+	quotes, err := srv.GetQuotes(ctx, []string{"Apple"})
+	require.NoError(t, err)
+	require.Equal(t, 105.0, quotes[0].SharePrice)
+	require.False(t, quotes[0].Stale)
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	require.Equal(t, 105.0, position.OpenPrice, "fresh cached quote is traded on")
+
+	srv.priceCache = NewPriceCache(0)
+	srv.priceCache.Set(&model.Shares{ShareName: "Apple", SharePrice: 105})
+	srv.priceRps = failingPriceRepository{}
+	quote, err := srv.GetQuote(ctx, "Apple")
+	require.NoError(t, err)
+	require.True(t, quote.Stale)
+
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	var staleQuote *model.StaleQuoteError
+	require.True(t, errors.As(err, &staleQuote))
+	_, err = srv.GetQuote(ctx, "Tesla")
+	require.Error(t, err)
+}
+
+func TestPendingSettlementsAreAppliedBeforeBalanceChanges(t *testing.T) {
+	srv, balanceRps, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	first, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 2})
+	require.NoError(t, err)
+	second, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	require.Equal(t, 700.0, balanceRps.balances[profileID])
+
+	balanceRps.failUpdates = 1
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: first.ID})
+	require.NoError(t, err)
+	require.Equal(t, 700.0, balanceRps.balances[profileID])
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: second.ID})
+	require.NoError(t, err)
+	require.Equal(t, 1000.0, balanceRps.balances[profileID], "the pending settlement is applied before the next one")
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.NoError(t, err)
+	balanceRps.failUpdates = 1
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+	balanceRps.failUpdates = 1
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.Error(t, err, "trading waits while a settlement is still pending")
+	balances := NewSettledBalanceService(NewBalanceService(balanceRps), srv)
+	_, err = balances.DepositMoney(ctx, &model.Balance{ProfileID: profileID, Balance: 50})
+	require.NoError(t, err)
+	require.Equal(t, 1050.0, balanceRps.balances[profileID], "the deposit is made after the pending settlement is applied")
+
+	pending, err := srv.tradingRps.GetPendingSettlements(ctx)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+	settlements, err := srv.GetSettlements(ctx, profileID, "")
+	require.NoError(t, err)
+	for _, settlement := range settlements {
+		require.Equal(t, model.SettlementStatusApplied, settlement.Status)
+	}
+}
+
+func TestTradingIsPausedWhileFeedIsInterrupted(t *testing.T) {
+	srv, _, _, profileID := setupTradingService(1000)
+	ctx := context.Background()
+
+	position, err := srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1, StopLoss: 90})
+	require.NoError(t, err)
+
+	srv.ProcessFeedEvent(&model.FeedEvent{Status: model.FeedInterrupted, Shares: []string{"Apple", "Tesla"}})
+	_, err = srv.OpenPosition(ctx, profileID, &model.OpenPosition{ShareName: "Apple", Amount: 1})
+	require.Error(t, err)
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.Error(t, err)
+	_, err = srv.CreateOrder(ctx, profileID, &model.CreateOrder{ShareName: "Apple", Amount: 1})
+	require.Error(t, err)
+
+	srv.ProcessPrice(ctx, &model.Shares{ShareName: "Apple", SharePrice: 95})
+	require.NoError(t, srv.checkFeed("Apple"), "a received price ends the interruption")
+	require.Error(t, srv.checkFeed("Tesla"), "the interruption of other shares goes on")
+	_, err = srv.ClosePosition(ctx, profileID, &model.ClosePosition{PositionID: position.ID})
+	require.NoError(t, err)
+
+	srv.ForgetFeedGaps([]string{"Apple"})
+	require.NoError(t, srv.checkFeed("Tesla"), "the interruption of a share no longer watched is dropped")
+}
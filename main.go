@@ -2,10 +2,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 
 	balanceProto "github.com/eugenshima/balance/proto"
+	priceServiceProto "github.com/eugenshima/price-service/proto"
 	profileProto "github.com/eugenshima/profile/proto"
+	"github.com/eugenshima/trading-api/internal/config"
 	"github.com/eugenshima/trading-api/internal/handlers"
 	"github.com/eugenshima/trading-api/internal/middleware"
 	"github.com/eugenshima/trading-api/internal/repository"
@@ -20,6 +23,12 @@ import (
 func main() {
 	e := echo.New()
 
+	cfg, err := config.NewConfig()
+	if err != nil {
+		fmt.Println("Error extracting env variables:", err)
+		return
+	}
+
 	profileConn, err := grpc.Dial(":8082", grpc.WithInsecure())
 	if err != nil {
 		return
@@ -59,13 +68,48 @@ func main() {
 	profileSrv := service.NewProfileService(profileRps)
 	handler := handlers.NewProfileAPIHandler(profileSrv)
 
-	// priceServiceClient := priceServiceProto.NewPriceServiceClient(priceServiceConn)
-	// priceServiceRps := repository.NewPriceServiceRepository(priceServiceClient)
+	priceServiceClient := priceServiceProto.NewPriceServiceClient(priceServiceConn)
+	priceServiceRps := repository.NewPriceServiceRepository(priceServiceClient)
 
 	balanceClient := balanceProto.NewBalanceServiceClient(balanceConn)
 	balanceRps := repository.NewBalanceRepository(balanceClient)
 	balanceSrv := service.NewBalanceService(balanceRps)
-	balanceHandler := handlers.NewBalanceAPIHandler(balanceSrv)
+
+	tradingRps, err := repository.NewTradingStoreRepository(cfg.TradingStorePath)
+	if err != nil {
+		fmt.Println("Error opening trading store:", err)
+		return
+	}
+	defer func() {
+		err = tradingRps.Close()
+		if err != nil {
+			fmt.Println("Error closing trading store")
+		}
+	}()
+	paperBalanceRps := repository.NewPaperBalanceRepository(tradingRps, cfg.PaperInitialBalance)
+	paperBalanceSrv := service.NewBalanceService(paperBalanceRps)
+	priceCache := service.NewPriceCache(cfg.QuoteMaxAge)
+	calendar, err := service.LoadMarketCalendar(cfg.MarketCalendarPath)
+	if err != nil {
+		fmt.Println("Error loading market calendar:", err)
+		return
+	}
+	tradingSrv := service.NewTradingService(tradingRps, priceServiceRps, balanceSrv, paperBalanceSrv, priceCache, calendar, cfg)
+	tradingHandler := handlers.NewTradingAPIHandler(tradingSrv)
+	balanceHandler := handlers.NewBalanceAPIHandler(service.NewSettledBalanceService(balanceSrv, tradingSrv))
+	marketHandler := handlers.NewMarketAPIHandler(tradingSrv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	priceSubscriptions := service.NewPriceSubscriptionManager(priceServiceRps, priceCache, cfg.PriceStreamRetryDelay, cfg.PriceStreamMaxRetryDelay,
+		cfg.PriceReplayBufferSize)
+	defer priceSubscriptions.Close()
+	priceMonitor := service.NewPriceMonitor(tradingSrv, priceSubscriptions, cfg.PriceMonitorInterval)
+	candleAggregator := service.NewCandleAggregator(priceSubscriptions, priceServiceRps, cfg.CandleShares, cfg.CandleHistorySize)
+	priceHandler := handlers.NewPriceAPIHandler(priceSubscriptions, tradingSrv, candleAggregator, cfg.PriceWSAllowedOrigins)
+	go priceMonitor.Run(ctx)
+	go candleAggregator.Run(ctx)
+	go tradingSrv.RunSettlementRecovery(ctx, cfg.SettlementRetryInterval)
 
 	middlewr := middleware.UserIdentity()
 
@@ -84,14 +128,42 @@ func main() {
 		balance.POST("/withdraw", balanceHandler.Withdraw, middlewr)
 		balance.POST("/createBalance", balanceHandler.CreateBalance, middlewr)
 	}
-	// in progress...
-	/*
-		trading := e.Group("/trading")
-		{
-			trading.POST("/openPosition", tradingHandler.OpenPosition, middlewr)
-			trading.POST("/closePosition", tradingHandler.ClosePosition, middlewr)
-		}
-	*/
+
+	trading := e.Group("/trading")
+	{
+		trading.POST("/openPosition", tradingHandler.OpenPosition, middlewr)
+		trading.POST("/closePosition", tradingHandler.ClosePosition, middlewr)
+		trading.POST("/increasePosition", tradingHandler.IncreasePosition, middlewr)
+		trading.GET("/positions", tradingHandler.GetPositions, middlewr)
+		trading.GET("/history", tradingHandler.GetTradeHistory, middlewr)
+		trading.GET("/liquidations", tradingHandler.GetLiquidations, middlewr)
+		trading.GET("/settlements", tradingHandler.GetSettlements, middlewr)
+		trading.POST("/orders", tradingHandler.CreateOrder, middlewr)
+		trading.POST("/orders/bracket", tradingHandler.CreateBracketOrder, middlewr)
+		trading.POST("/orders/oco", tradingHandler.CreateOCOOrder, middlewr)
+		trading.GET("/orders", tradingHandler.GetPendingOrders, middlewr)
+		trading.DELETE("/orders/:id", tradingHandler.CancelOrder, middlewr)
+		trading.POST("/paper/reset", tradingHandler.ResetPaperBalance, middlewr)
+	}
+
+	portfolio := e.Group("/portfolio")
+	{
+		portfolio.GET("", tradingHandler.GetPortfolio, middlewr)
+	}
+
+	prices := e.Group("/prices")
+	{
+		prices.GET("/ws", priceHandler.PricesWS, middleware.TokenFromQuery("token"), middlewr)
+		prices.GET("/stream", priceHandler.PricesSSE, middleware.TokenFromQuery("token"), middlewr)
+		prices.GET("", priceHandler.GetQuotes, middlewr)
+		prices.GET("/:share", priceHandler.GetQuote, middlewr)
+		prices.GET("/:share/candles", priceHandler.GetCandles, middlewr)
+	}
+
+	market := e.Group("/market")
+	{
+		market.GET("/status", marketHandler.GetMarketStatus, middlewr)
+	}
 
 	e.Logger.Fatal(e.Start(":8089"))
 }